@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/sapcc/kube-detective/pkg/detective"
@@ -17,6 +18,7 @@ var (
 	opts       detective.Options
 	kubeconfig string
 	context    string
+	qps        float64
 )
 
 func init() {
@@ -26,7 +28,44 @@ func init() {
 	flag.BoolVar(&opts.TestServices, "services", true, "test services")
 	flag.BoolVar(&opts.TestExternalIPs, "externalips", false, "test external IPs")
 	flag.BoolVar(&opts.TestServiceName, "service-name", true, "test service name resolution from each pod")
+	flag.BoolVar(&opts.TestAPIServer, "apiserver", false, "test kubernetes API service (ClusterIP and DNS name) over HTTPS from each pod, requires curl in the test image")
+	flag.StringVar(&opts.EgressTargets, "egress", "", "comma separated list of external targets ([protocol://]host:port[=reachable|unreachable]) to probe from each pod")
+	flag.StringVar(&opts.EgressTargetsFile, "egress-file", "", "file with one external target per line to probe from each pod")
+	flag.StringVar(&opts.ForbiddenTargets, "forbidden", "", "comma separated list of targets ([protocol://]host:port) pods must not reach. @nodes expands to every node's InternalIP")
+	flag.StringVar(&opts.ForbiddenTargetsFile, "forbidden-file", "", "file with one target per line pods must not reach")
+	flag.BoolVar(&opts.TestIngress, "ingress", false, "test ingress controller with an ingress per service")
+	flag.StringVar(&opts.IngressClass, "ingress-class", "", "ingress class used for test ingresses")
+	flag.StringVar(&opts.IngressRouting, "ingress-routing", "path", "route test ingresses by path or host")
+	flag.StringVar(&opts.IngressDomain, "ingress-domain", "", "domain used for host based ingress routing")
+	flag.StringVar(&opts.IngressAddress, "ingress-address", "", "address of the ingress controller. @node probes the controller on the source pod's node (default: ingress status)")
+	flag.BoolVar(&opts.TestAffinity, "affinity", false, "test session affinity and load balancing of a service backed by pods on all nodes")
+	flag.IntVar(&opts.AffinityRequests, "affinity-requests", 10, "Number of requests per pod for the session affinity tests")
+	flag.BoolVar(&opts.TestDistribution, "distribution", false, "test load distribution of a service backed by pods on all nodes")
+	flag.IntVar(&opts.DistributionRequests, "distribution-requests", 100, "Number of requests per pod for the load distribution test")
+	flag.BoolVar(&opts.TestConvergence, "convergence", false, "measure how long it takes each node to follow a service endpoint change")
+	flag.DurationVar(&opts.ConvergenceTimeout, "convergence-timeout", detective.WaitForEndpointTimeout, "time after which a node is considered to never converge")
+	flag.BoolVar(&opts.ReportPodLatency, "pod-latency", false, "report per node how long test pods took to be scheduled, running and reachable")
+	flag.BoolVar(&opts.UseEndpointSlices, "endpointslices", false, "wait for EndpointSlices instead of Endpoints and check both for consistency with the selected pods")
+	flag.DurationVar(&opts.PodStartTimeout, "pod-start-timeout", detective.PodStartTimeout, "time to wait for test pods to be running")
+	flag.DurationVar(&opts.ServiceAccountTimeout, "serviceaccount-timeout", detective.ServiceAccountTimeout, "time to wait for the default service account in the test namespace")
+	flag.DurationVar(&opts.EndpointsTimeout, "endpoints-timeout", detective.WaitForEndpointTimeout, "time to wait for service endpoints and ingress addresses")
 	flag.IntVar(&opts.WorkerCount, "workers", 10, "Number of workers to run checks in parallel")
+	flag.StringVar(&opts.Sample, "sample", "all", "node pairs to test: all, random:k, pairs, zones:k or cover:k")
+	flag.Int64Var(&opts.SampleSeed, "sample-seed", time.Now().UnixNano(), "seed for random sampling")
+	flag.StringVar(&opts.Report, "report", "", "write the results and their analysis as JSON to this file")
+	flag.StringVar(&opts.Dot, "dot", "", "write a Graphviz graph of the failing and slow checks to this file")
+	flag.DurationVar(&opts.Slow, "slow", detective.SlowProbe, "duration after which a passing check is drawn as slow in the -dot graph, including the exec round trip")
+	flag.StringVar(&opts.ResultsDir, "results", "", "directory to collect diagnostics of failed checks into")
+	flag.BoolVar(&opts.NodeDiagnostics, "node-diagnostics", false, "run the hostNetwork test pods privileged and collect network diagnostics of nodes involved in failures")
+	flag.IntVar(&opts.Captures, "capture", 0, "re-run up to this many failed checks while capturing packets on the source and target nodes")
+	flag.BoolVar(&opts.Keep, "keep", false, "keep the test namespace after the run to reproduce failures manually")
+	flag.IntVar(&opts.Retries, "retries", 0, "Number of retries of a failed check before it is considered a failure")
+	flag.DurationVar(&opts.RetryBackoff, "retry-backoff", detective.RetryBackoff, "time to wait before the first retry, doubled for each further retry")
+	flag.IntVar(&opts.MaxPerSourceNode, "max-per-source-node", 2, "Maximum number of checks in flight from the same node, 0 for no limit")
+	flag.IntVar(&opts.MaxPerTargetNode, "max-per-target-node", 4, "Maximum number of checks in flight to the same node, 0 for no limit")
+	flag.IntVar(&opts.CreateWorkerCount, "create-workers", 0, "Number of workers to create test pods and services in parallel (default: -workers)")
+	flag.Float64Var(&qps, "qps", 50, "Maximum queries per second to the API server")
+	flag.IntVar(&opts.Burst, "burst", 100, "Maximum burst of queries to the API server")
 	flag.StringVar(&opts.TestImage, "test-image", "gcr.io/google_containers/serve_hostname:1.2", "test external IPs")
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Explicit kubeconfig (default: $KUBECONFIG)")
 	flag.StringVar(&context, "context", os.Getenv("KUBECONTEXT"), "context to use from kubeconfig (default: $KUBECONTEXT, current-context)")
@@ -35,6 +74,7 @@ func init() {
 func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
+	opts.QPS = float32(qps)
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
@@ -0,0 +1,239 @@
+package detective
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	ScenarioAffinity = "affinity"
+	ScenarioBalanced = "balanced"
+)
+
+// createBackendService creates a service selecting the test pods on all
+// nodes. Only pods without hostNetwork are selected, so every backend
+// responds with a distinct hostname.
+func (d *Detective) createBackendService(scenario string, affinity core.ServiceAffinity) error {
+	backends, err := d.backendPods()
+	if err != nil {
+		return err
+	}
+
+	spec := &core.Service{
+		ObjectMeta: meta.ObjectMeta{
+			GenerateName: scenario + "-",
+			Labels: map[string]string{
+				"scenario": scenario,
+			},
+		},
+		Spec: core.ServiceSpec{
+			Type:            core.ServiceTypeClusterIP,
+			SessionAffinity: affinity,
+			Ports: []core.ServicePort{
+				{
+					Port:       ServiceHttpPort,
+					TargetPort: intstr.IntOrString{IntVal: PodHttpPort},
+				},
+			},
+			Selector: map[string]string{
+				"hostNetwork": "false",
+			},
+		},
+	}
+
+	service, err := d.client.CoreV1().Services(d.namespace.Name).Create(d.tomb.Context(nil), spec, meta.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	d.endpointsReady.expect(service.Name, len(backends))
+	klog.V(3).Infof("  created %v with session affinity %v", service.Name, affinity)
+
+	return nil
+}
+
+func (d *Detective) createBackendServices() error {
+	klog.V(2).Info("Creating backend services")
+
+	if err := d.createBackendService(ScenarioAffinity, core.ServiceAffinityClientIP); err != nil {
+		return err
+	}
+
+	return d.createBackendService(ScenarioBalanced, core.ServiceAffinityNone)
+}
+
+func (d *Detective) getBackendService(scenario string) (*core.Service, error) {
+	services, err := d.informers.Core().V1().Services().Lister().Services(d.namespace.Name).List(labels.SelectorFromSet(labels.Set{"scenario": scenario}))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(services) == 0 {
+		return nil, fmt.Errorf("No %v service found", scenario)
+	}
+	return services[0], nil
+}
+
+// backendPods lists the pods selected by the backend services
+func (d *Detective) backendPods() ([]*core.Pod, error) {
+	return d.listPods(false)
+}
+
+func (d *Detective) waitForBackendServiceEndpoints() error {
+	klog.V(2).Info("Waiting for backend service endpoints")
+
+	err := d.endpointsReady.wait(d.tomb.Dying(), d.endpointsTimeout)
+	if err == wait.ErrWaitTimeout {
+		if derr := d.diagnoseMissingEndpoints(); derr != nil {
+			return derr
+		}
+		return fmt.Errorf("Backend service endpoints not ready within %v", d.endpointsTimeout)
+	}
+	return err
+}
+
+// hitSessionAffinity verifies that all requests from a source land on the
+// same backend
+func (d *Detective) hitSessionAffinity(sourceHostNetwork bool) error {
+	service, err := d.getBackendService(ScenarioAffinity)
+	if err != nil {
+		return err
+	}
+
+	return d.hitBackendService(sourceHostNetwork, func(pod *core.Pod) error {
+		responses, err := d.wgetRepeatedly(pod, serviceURL(service), d.affinityRequests)
+		backends := countBackends(responses)
+
+		if err == nil && (len(backends) != 1 || backends[""] > 0) {
+			err = fmt.Errorf("Requests from %v to %v with session affinity reached %v", pod.Name, service.Name, describeBackends(backends))
+		}
+
+		d.printBackends("Affinity", pod, service, backends, wgetRepeatedlyCommand(serviceURL(service), d.affinityRequests), err)
+		return err
+	})
+}
+
+// hitLoadBalancing verifies that requests without session affinity are
+// distributed over more than one backend. Whether every backend is reached
+// is verified by hitLoadDistribution with enough requests per backend.
+func (d *Detective) hitLoadBalancing(sourceHostNetwork bool) error {
+	service, err := d.getBackendService(ScenarioBalanced)
+	if err != nil {
+		return err
+	}
+
+	backendPods, err := d.backendPods()
+	if err != nil {
+		return err
+	}
+
+	return d.hitBackendService(sourceHostNetwork, func(pod *core.Pod) error {
+		responses, err := d.wgetRepeatedly(pod, serviceURL(service), d.affinityRequests)
+		backends := countBackends(responses)
+
+		if err == nil && (backends[""] > 0 || len(backendPods) > 1 && len(backends) < 2) {
+			err = fmt.Errorf("Requests from %v to %v without session affinity reached %v", pod.Name, service.Name, describeBackends(backends))
+		}
+
+		d.printBackends("Balanced", pod, service, backends, wgetRepeatedlyCommand(serviceURL(service), d.affinityRequests), err)
+		return err
+	})
+}
+
+func (d *Detective) hitBackendService(sourceHostNetwork bool, hit func(pod *core.Pod) error) error {
+	sources, err := d.listPods(sourceHostNetwork)
+	if err != nil {
+		return err
+	}
+
+	return d.runProbes(len(sources), func(i int) NodePair {
+		return NodePair{Source: sources[i].Spec.NodeName}
+	}, func(i int) error {
+		return hit(sources[i])
+	})
+}
+
+// wgetRepeatedly issues count requests within a single exec. It returns one
+// response per request, failed requests yield an empty response.
+func (d *Detective) wgetRepeatedly(pod *core.Pod, url string, count int) ([]string, error) {
+	out, err := d.exec(pod, wgetRepeatedlyCommand(url, count))
+	if err != nil {
+		return nil, err
+	}
+
+	responses := strings.Split(out, "\n")
+	if len(responses) < count {
+		return nil, fmt.Errorf("Expected %v responses, got %v", count, len(responses))
+	}
+
+	responses = responses[:count]
+	for i := range responses {
+		responses[i] = strings.TrimSpace(responses[i])
+	}
+	return responses, nil
+}
+
+func wgetRepeatedlyCommand(url string, count int) []string {
+	script := fmt.Sprintf("for i in $(seq %d); do wget -q --timeout=10 -O- %v; echo; done", count, url)
+	return []string{"sh", "-c", script}
+}
+
+func serviceURL(service *core.Service) string {
+	return fmt.Sprintf("http://%v:%v", service.Spec.ClusterIP, service.Spec.Ports[0].Port)
+}
+
+// countBackends counts the responses per backend. Failed requests are
+// counted as backend "".
+func countBackends(responses []string) map[string]int {
+	backends := map[string]int{}
+	for _, response := range responses {
+		backends[response]++
+	}
+	return backends
+}
+
+func describeBackends(backends map[string]int) string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		if name == "" {
+			parts = append(parts, fmt.Sprintf("%v failed", backends[name]))
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%v:%v", name, backends[name]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// printBackends prints and records the backends reached from pod along with
+// the command to reproduce a failure
+func (d *Detective) printBackends(scenario string, pod *core.Pod, service *core.Service, backends map[string]int, command []string, err error) {
+	if err != nil {
+		klog.V(3).Infof("Error: '%s'", err)
+	}
+
+	result := checkResult(scenario, pod, "", ErrorMismatch, err)
+	reproduce := d.reproduce(&result, pod, command)
+	fmt.Printf("[%v] %30v --> %-10v %-15v --> %-15v   %v%v\n",
+		result.Status(),
+		pod.Spec.NodeName,
+		scenario,
+		pod.Status.PodIP,
+		service.Spec.ClusterIP,
+		describeBackends(backends),
+		reproduce,
+	)
+	d.record(result)
+}
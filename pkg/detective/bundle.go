@@ -0,0 +1,175 @@
+package detective
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// bundle is a gzipped tarball of diagnostics
+type bundle struct {
+	file *os.File
+	gzip *gzip.Writer
+	tar  *tar.Writer
+	dir  string
+}
+
+func newBundle(path string) (*bundle, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the files are put into a directory named like the tarball
+	gz := gzip.NewWriter(f)
+	dir := strings.TrimSuffix(filepath.Base(path), ".tar.gz")
+
+	return &bundle{file: f, gzip: gz, tar: tar.NewWriter(gz), dir: dir}, nil
+}
+
+func (b *bundle) add(name string, data []byte) error {
+	err := b.tar.WriteHeader(&tar.Header{
+		Name:    filepath.Join(b.dir, name),
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = b.tar.Write(data)
+	return err
+}
+
+func (b *bundle) addJSON(name string, obj interface{}) error {
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return err
+	}
+	return b.add(name, data)
+}
+
+func (b *bundle) Close() error {
+	var result *multierror.Error
+	result = multierror.Append(result, b.tar.Close())
+	result = multierror.Append(result, b.gzip.Close())
+	result = multierror.Append(result, b.file.Close())
+	return result.ErrorOrNil()
+}
+
+// failedResults returns the probes which failed or reached a forbidden
+// target
+func (d *Detective) failedResults() []ProbeResult {
+	var failed []ProbeResult
+	for _, r := range d.results {
+		if r.Outcome != OutcomePass && r.Outcome != OutcomeFlaky {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// collectBundle writes the evidence of failed probes into a tarball in the
+// results directory. It does nothing if all probes passed.
+func (d *Detective) collectBundle() error {
+	failed := d.failedResults()
+	if len(failed) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(d.resultsDir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(d.resultsDir, d.namespace.Name+".tar.gz")
+	b, err := newBundle(path)
+	if err != nil {
+		return err
+	}
+
+	var result *multierror.Error
+	result = multierror.Append(result, d.collectDiagnostics(b, failed))
+	result = multierror.Append(result, b.Close())
+
+	if err := result.ErrorOrNil(); err != nil {
+		return fmt.Errorf("Couldn't collect diagnostics into %v: %v", path, err)
+	}
+
+	fmt.Printf("Diagnostics of %v failures written to %v\n", len(failed), path)
+	return nil
+}
+
+func (d *Detective) collectDiagnostics(b *bundle, failed []ProbeResult) error {
+	var result *multierror.Error
+	ctx := d.tomb.Context(nil)
+
+	result = multierror.Append(result, b.addJSON("probes.json", failed))
+
+	pods, err := d.informers.Core().V1().Pods().Lister().Pods(d.namespace.Name).List(labels.Everything())
+	result = multierror.Append(result, err)
+	for _, pod := range pods {
+		result = multierror.Append(result, b.addJSON(filepath.Join("pods", pod.Name+".json"), pod))
+	}
+
+	events, err := d.client.CoreV1().Events(d.namespace.Name).List(ctx, meta.ListOptions{})
+	result = multierror.Append(result, err)
+	if err == nil {
+		result = multierror.Append(result, b.addJSON("events.json", events.Items))
+	}
+
+	nodes, targets := sets.NewString(), sets.NewString()
+	for _, r := range failed {
+		if r.Source != "" {
+			nodes.Insert(r.Source)
+		}
+		if r.Target != "" {
+			nodes.Insert(r.Target)
+			targets.Insert(r.Target)
+		}
+	}
+
+	for _, name := range nodes.List() {
+		node, err := d.client.CoreV1().Nodes().Get(ctx, name, meta.GetOptions{})
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		result = multierror.Append(result, b.addJSON(filepath.Join("nodes", name+".json"), struct {
+			Name       string               `json:"name"`
+			Labels     map[string]string    `json:"labels"`
+			Conditions []core.NodeCondition `json:"conditions"`
+			Addresses  []core.NodeAddress   `json:"addresses"`
+		}{node.Name, node.Labels, node.Status.Conditions, node.Status.Addresses}))
+	}
+
+	if d.nodeDiagnostics {
+		result = multierror.Append(result, d.collectNodeDiagnostics(b, nodes.List()))
+	}
+
+	services, err := d.informers.Core().V1().Services().Lister().Services(d.namespace.Name).List(labels.Everything())
+	result = multierror.Append(result, err)
+	for _, service := range services {
+		if !targets.Has(service.Labels["nodeName"]) {
+			continue
+		}
+		result = multierror.Append(result, b.addJSON(filepath.Join("services", service.Name+".json"), service))
+
+		if endpoints, err := d.informers.Core().V1().Endpoints().Lister().Endpoints(d.namespace.Name).Get(service.Name); err == nil {
+			result = multierror.Append(result, b.addJSON(filepath.Join("endpoints", service.Name+".json"), endpoints))
+		}
+	}
+
+	return result.ErrorOrNil()
+}
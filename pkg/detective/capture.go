@@ -0,0 +1,175 @@
+package detective
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	core "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// CaptureDelay is the time tcpdump gets to start before the probe is
+	// re-run
+	CaptureDelay = 2 * time.Second
+	// CaptureDuration is the time packets are captured. It covers the
+	// timeout of wget.
+	CaptureDuration = 15 * time.Second
+)
+
+// captured counts the failures captured so far and queues them until the
+// scenario completes
+type captured struct {
+	mutex sync.Mutex
+	count int
+	queue []capture
+}
+
+// capture is a failed probe to re-run while capturing packets. Result is the
+// index of the recorded result.
+type capture struct {
+	id     int
+	result int
+	filter string
+	probe  func() (string, error)
+}
+
+// queueCapture queues the probe of a failed result to be re-run while
+// capturing the packets matching filter, unless max captures were queued
+// already. The captures run after the scenario completed, so they don't hold
+// the scheduler's slots of the nodes.
+func (d *Detective) queueCapture(result ProbeResult, index int, filter string, probe func() (string, error)) {
+	if result.Outcome != OutcomeFail || result.Class == ErrorExec {
+		return
+	}
+
+	d.captured.mutex.Lock()
+	defer d.captured.mutex.Unlock()
+
+	if d.captured.count >= d.captures {
+		return
+	}
+	d.captured.count++
+	d.captured.queue = append(d.captured.queue, capture{id: d.captured.count, result: index, filter: filter, probe: probe})
+}
+
+// runCaptures runs the queued captures one after another
+func (d *Detective) runCaptures() {
+	d.captured.mutex.Lock()
+	queue := d.captured.queue
+	d.captured.queue = nil
+	d.captured.mutex.Unlock()
+
+	for _, c := range queue {
+		if !d.tomb.Alive() {
+			return
+		}
+		d.captureFailure(c)
+	}
+}
+
+// captureFailure re-runs the probe of a failed result while capturing the
+// packets on the source and target nodes. The pcap files are saved into the
+// results directory and added to the result along with the outcome of the
+// re-run.
+func (d *Detective) captureFailure(c capture) {
+	d.resultsMutex.Lock()
+	result := d.results[c.result]
+	d.resultsMutex.Unlock()
+
+	pods, err := d.listPods(true)
+	if err != nil {
+		klog.V(3).Infof("Error: '%s'", err)
+		return
+	}
+
+	nodes := []string{result.Source}
+	if result.Target != "" && result.Target != result.Source {
+		nodes = append(nodes, result.Target)
+	}
+
+	dir := filepath.Join(d.resultsDir, d.namespace.Name+"-pcap")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		klog.V(3).Infof("Error: '%s'", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var errs *multierror.Error
+	var captures []string
+
+	for _, node := range nodes {
+		var pod *core.Pod
+		for _, p := range pods {
+			if p.Spec.NodeName == node {
+				pod = p
+			}
+		}
+		if pod == nil {
+			mutex.Lock()
+			errs = multierror.Append(errs, fmt.Errorf("No hostNetwork pod on %v to capture", node))
+			mutex.Unlock()
+			continue
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("%03d-%v.pcap", c.id, node))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := d.tcpdump(pod, c.filter, path)
+
+			mutex.Lock()
+			if err != nil {
+				errs = multierror.Append(errs, err)
+			} else {
+				captures = append(captures, path)
+			}
+			mutex.Unlock()
+		}()
+	}
+
+	time.Sleep(CaptureDelay)
+	_, err = c.probe()
+	wg.Wait()
+
+	if errs != nil {
+		klog.V(3).Infof("Error: '%s'", errs)
+	}
+
+	rerun := OutcomePass
+	if err != nil {
+		rerun = OutcomeFail + " " + errorClass(err)
+	}
+
+	d.resultsMutex.Lock()
+	d.results[c.result].Captures = captures
+	d.results[c.result].CaptureOutcome = rerun
+	d.resultsMutex.Unlock()
+
+	fmt.Printf("  captured %v (%v) into %v, the re-run was a %v\n", result, c.filter, captures, rerun)
+}
+
+// tcpdump captures the packets matching filter on the node of the
+// hostNetwork pod for CaptureDuration and writes them to path
+func (d *Detective) tcpdump(pod *core.Pod, filter, path string) error {
+	script := fmt.Sprintf("tcpdump -i any -U -w - '%v' & pid=$!; sleep %d; kill -INT $pid; wait $pid", filter, int(CaptureDuration.Seconds()))
+	stdout, stderr, err := d.ExecWithOptions(ExecOptions{
+		Command:            []string{"sh", "-c", script},
+		Namespace:          d.namespace.Name,
+		PodName:            pod.Name,
+		ContainerName:      "server",
+		CaptureStderr:      true,
+		CaptureStdout:      true,
+		PreserveWhitespace: true,
+	})
+	if len(stdout) == 0 {
+		return fmt.Errorf("Capturing on %v failed: %v %v", pod.Spec.NodeName, stderr, err)
+	}
+
+	return os.WriteFile(path, []byte(stdout), 0644)
+}
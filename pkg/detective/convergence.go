@@ -0,0 +1,237 @@
+package detective
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog/v2"
+)
+
+const (
+	ScenarioConvergence = "convergence"
+)
+
+// ConvergencePollInterval is the pause between two dials of a node polling
+// for the new backend
+const ConvergencePollInterval = 200 * time.Millisecond
+
+type Convergence struct {
+	source   *core.Pod
+	backend  *core.Pod
+	duration time.Duration
+	err      error
+}
+
+func (d *Detective) createConvergenceService() error {
+	klog.V(2).Info("Creating convergence service")
+
+	spec := &core.Service{
+		ObjectMeta: meta.ObjectMeta{
+			GenerateName: ScenarioConvergence + "-",
+			Labels: map[string]string{
+				"scenario": ScenarioConvergence,
+			},
+		},
+		Spec: core.ServiceSpec{
+			Type: core.ServiceTypeClusterIP,
+			Ports: []core.ServicePort{
+				{
+					Port:       ServiceHttpPort,
+					TargetPort: intstr.IntOrString{IntVal: PodHttpPort},
+				},
+			},
+			Selector: map[string]string{
+				ScenarioConvergence: "active",
+			},
+		},
+	}
+
+	service, err := d.client.CoreV1().Services(d.namespace.Name).Create(d.tomb.Context(nil), spec, meta.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	klog.V(3).Infof("  created %v", service.Name)
+
+	return nil
+}
+
+// setConvergenceBackend adds or removes the label selected by the
+// convergence service
+func (d *Detective) setConvergenceBackend(pod *core.Pod, active bool) error {
+	value := "null"
+	if active {
+		value = `"active"`
+	}
+
+	patch := fmt.Sprintf(`{"metadata":{"labels":{%q:%v}}}`, ScenarioConvergence, value)
+	_, err := d.client.CoreV1().Pods(d.namespace.Name).Patch(d.tomb.Context(nil), pod.Name, types.StrategicMergePatchType, []byte(patch), meta.PatchOptions{})
+	return err
+}
+
+// measureConvergence moves the convergence service from one backend to
+// another and measures per source node how long it takes until traffic
+// reaches the new backend. The resolution is one exec round trip.
+func (d *Detective) measureConvergence() error {
+	service, err := d.getBackendService(ScenarioConvergence)
+	if err != nil {
+		return err
+	}
+
+	sources, err := d.backendPods()
+	if err != nil {
+		return err
+	}
+
+	if len(sources) < 2 {
+		return fmt.Errorf("At least 2 nodes are needed to measure endpoint convergence")
+	}
+
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Name < sources[j].Name })
+	from, to := sources[0], sources[1]
+
+	klog.V(2).Infof("Moving %v to %v", service.Name, from.Name)
+	if err := d.setConvergenceBackend(from, true); err != nil {
+		return err
+	}
+
+	// sources which never reach the initial backend are failures, the others
+	// are still measured
+	baseline := d.pollConvergence(sources, service, from, time.Now())
+	if !d.tomb.Alive() {
+		return fmt.Errorf("Interrupted")
+	}
+	var reachable []*core.Pod
+	var unreachable []Convergence
+	for _, c := range baseline {
+		if c.err != nil {
+			c.err = fmt.Errorf("%v on %v never reached initial backend %v via %v", c.source.Name, c.source.Spec.NodeName, from.Name, service.Name)
+			unreachable = append(unreachable, c)
+			continue
+		}
+		reachable = append(reachable, c.source)
+	}
+
+	klog.V(2).Infof("Moving %v from %v to %v", service.Name, from.Name, to.Name)
+	start := time.Now()
+	if err := d.setConvergenceBackend(from, false); err != nil {
+		return err
+	}
+	if err := d.setConvergenceBackend(to, true); err != nil {
+		return err
+	}
+
+	convergences := d.pollConvergence(reachable, service, to, start)
+	if !d.tomb.Alive() {
+		return fmt.Errorf("Interrupted")
+	}
+
+	var result *multierror.Error
+	for _, c := range append(unreachable, convergences...) {
+		if c.err != nil {
+			klog.V(3).Infof("Error: '%s'", c.err)
+
+			result = multierror.Append(result, c.err)
+		}
+
+		r := checkResult("Convergence", c.source, c.backend.Spec.NodeName, ErrorTimeout, c.err)
+		reproduce := d.reproduce(&r, c.source, dialCommand(service.Spec.ClusterIP, service.Spec.Ports[0].Port))
+		fmt.Printf("[%v] %30v --> Convergence %-15v --> %-15v   %v%v\n",
+			r.Status(),
+			c.source.Spec.NodeName,
+			c.source.Status.PodIP,
+			service.Spec.ClusterIP,
+			c.duration.Round(time.Millisecond),
+			reproduce,
+		)
+		d.record(r)
+	}
+
+	printConvergence(convergences)
+
+	return result.ErrorOrNil()
+}
+
+// pollConvergence dials the service from every source until it is served
+// by backend. All sources are polled at once, independent of -workers, so
+// no node waits for others before its first dial. The durations are
+// measured relative to start.
+func (d *Detective) pollConvergence(sources []*core.Pod, service *core.Service, backend *core.Pod, start time.Time) []Convergence {
+	convergences := make([]Convergence, len(sources))
+
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source *core.Pod) {
+			defer wg.Done()
+			convergences[i] = d.pollSource(source, service, backend, start)
+		}(i, source)
+	}
+	wg.Wait()
+
+	return convergences
+}
+
+// pollSource dials the service from source every ConvergencePollInterval
+// until it is served by backend or the convergence timeout expires
+func (d *Detective) pollSource(source *core.Pod, service *core.Service, backend *core.Pod, start time.Time) Convergence {
+	c := Convergence{source: source, backend: backend}
+	timeout := time.NewTimer(time.Until(start.Add(d.convergenceTimeout)))
+	defer timeout.Stop()
+
+	for {
+		response, err := d.dial(source, service.Spec.ClusterIP, service.Spec.Ports[0].Port)
+		if err == nil && strings.TrimSpace(response) == backend.Name {
+			c.duration = time.Since(start)
+			return c
+		}
+
+		select {
+		case <-time.After(ConvergencePollInterval):
+			continue
+		case <-timeout.C:
+		case <-d.tomb.Dying():
+		}
+
+		c.duration = time.Since(start)
+		c.err = fmt.Errorf("%v on %v did not reach %v via %v within %v", source.Name, source.Spec.NodeName, backend.Name, service.Name, d.convergenceTimeout)
+		return c
+	}
+}
+
+func printConvergence(convergences []Convergence) {
+	var durations []time.Duration
+	var stuck []string
+	for _, c := range convergences {
+		if c.err != nil {
+			stuck = append(stuck, c.source.Spec.NodeName)
+			continue
+		}
+		durations = append(durations, c.duration)
+	}
+
+	if len(durations) > 0 {
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		fmt.Printf("Endpoint convergence on %v nodes: min %v, median %v, max %v\n",
+			len(durations),
+			durations[0].Round(time.Millisecond),
+			durations[len(durations)/2].Round(time.Millisecond),
+			durations[len(durations)-1].Round(time.Millisecond),
+		)
+	}
+
+	if len(stuck) > 0 {
+		sort.Strings(stuck)
+		fmt.Printf("Nodes that never converged (%v)\n", len(stuck))
+		for _, node := range stuck {
+			fmt.Printf("  %v\n", node)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"os"
 	"regexp"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
@@ -26,24 +27,79 @@ var (
 
 const (
 	PodStartTimeout         = 1 * time.Minute
+	ServiceAccountTimeout   = 1 * time.Minute
 	WaitForEndpointInterval = 5 * time.Second
 	WaitForEndpointTimeout  = 1 * time.Minute
 	InformerResyncPeriod    = 1 * time.Minute
+	RetryBackoff            = 1 * time.Second
 
 	PodHttpPort     = 9376
 	ServiceHttpPort = 9377
+
+	ServiceAccountCACert = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
 )
 
 type Options struct {
-	WorkerCount     int
-	ExternalCIDR    string
-	NodeFilterRegex string
-	TestImage       string
-	TestPods        bool
-	TestServices    bool
-	TestServiceName bool
-	TestExternalIPs bool
-	RestConfig      *rest.Config
+	WorkerCount      int
+	ExternalCIDR     string
+	NodeFilterRegex  string
+	TestImage        string
+	TestPods         bool
+	TestServices     bool
+	TestServiceName  bool
+	TestExternalIPs  bool
+	TestAPIServer    bool
+	TestIngress      bool
+	TestAffinity     bool
+	TestDistribution bool
+	TestConvergence  bool
+	ReportPodLatency bool
+
+	UseEndpointSlices bool
+
+	CreateWorkerCount int
+	MaxPerSourceNode  int
+	MaxPerTargetNode  int
+	QPS               float32
+	Burst             int
+
+	Sample     string
+	SampleSeed int64
+
+	Retries      int
+	RetryBackoff time.Duration
+
+	Report string
+	Dot    string
+	Slow   time.Duration
+
+	ResultsDir string
+
+	NodeDiagnostics bool
+	Captures        int
+
+	Keep bool
+
+	PodStartTimeout       time.Duration
+	ServiceAccountTimeout time.Duration
+	EndpointsTimeout      time.Duration
+
+	AffinityRequests     int
+	DistributionRequests int
+	ConvergenceTimeout   time.Duration
+
+	IngressClass   string
+	IngressRouting string
+	IngressDomain  string
+	IngressAddress string
+
+	EgressTargets     string
+	EgressTargetsFile string
+
+	ForbiddenTargets     string
+	ForbiddenTargetsFile string
+
+	RestConfig *rest.Config
 }
 
 type Detective struct {
@@ -51,10 +107,50 @@ type Detective struct {
 	config    *rest.Config
 	informers informers.SharedInformerFactory
 
-	namespace   *core.Namespace
-	externalIPs []string
-	nodeFilter  *regexp.Regexp
-	workerCount int
+	podsReady      *readiness
+	endpointsReady *readiness
+
+	namespace     *core.Namespace
+	externalIPs   []string
+	egressTargets []ExternalTarget
+	forbidden     []ExternalTarget
+	nodeFilter    *regexp.Regexp
+	workerCount   int
+
+	createWorkerCount int
+	maxPerSourceNode  int
+	maxPerTargetNode  int
+
+	sampling Sampling
+	samples  map[NodePair]bool
+
+	retries      int
+	retryBackoff time.Duration
+	slow         time.Duration
+	resultsDir   string
+	results      []ProbeResult
+	resultsMutex sync.Mutex
+
+	nodeDiagnostics bool
+	captures        int
+	captured        captured
+
+	keep bool
+
+	podLatency bool
+
+	podStartTimeout       time.Duration
+	serviceAccountTimeout time.Duration
+	endpointsTimeout      time.Duration
+
+	affinityRequests     int
+	distributionRequests int
+	convergenceTimeout   time.Duration
+
+	ingressClass   string
+	ingressRouting string
+	ingressDomain  string
+	ingressAddress string
 
 	tomb      *tomb.Tomb
 	outerTomb *tomb.Tomb
@@ -79,6 +175,10 @@ func NewDetective(opts Options) *Detective {
 		d.externalIPs = opts.externalIPs()
 	}
 
+	d.sampling = opts.sampling()
+	d.egressTargets = opts.egressTargets()
+	d.forbidden = opts.forbiddenTargets()
+
 	e, err := regexp.Compile(opts.NodeFilterRegex)
 	if err != nil {
 		fmt.Println("The -nodeFilter paramter is not a valid regex")
@@ -89,11 +189,99 @@ func NewDetective(opts Options) *Detective {
 
 	d.testImage = opts.TestImage
 
+	d.podStartTimeout = opts.PodStartTimeout
+	if d.podStartTimeout <= 0 {
+		d.podStartTimeout = PodStartTimeout
+	}
+
+	d.serviceAccountTimeout = opts.ServiceAccountTimeout
+	if d.serviceAccountTimeout <= 0 {
+		d.serviceAccountTimeout = ServiceAccountTimeout
+	}
+
+	d.endpointsTimeout = opts.EndpointsTimeout
+	if d.endpointsTimeout <= 0 {
+		d.endpointsTimeout = WaitForEndpointTimeout
+	}
+
+	d.affinityRequests = opts.AffinityRequests
+	if d.affinityRequests < 2 {
+		d.affinityRequests = 10
+	}
+
+	d.distributionRequests = opts.DistributionRequests
+	if d.distributionRequests < 1 {
+		d.distributionRequests = 100
+	}
+
+	d.convergenceTimeout = opts.ConvergenceTimeout
+	if d.convergenceTimeout <= 0 {
+		d.convergenceTimeout = WaitForEndpointTimeout
+	}
+
+	if opts.TestIngress {
+		switch opts.IngressRouting {
+		case IngressRoutingPath:
+		case IngressRoutingHost:
+			if opts.IngressDomain == "" {
+				fmt.Println("You need to provide a flag -ingress-domain for host based routing")
+				os.Exit(1)
+			}
+		default:
+			fmt.Printf("Unknown -ingress-routing %q\n", opts.IngressRouting)
+			os.Exit(1)
+		}
+
+		d.ingressClass = opts.IngressClass
+		d.ingressRouting = opts.IngressRouting
+		d.ingressDomain = opts.IngressDomain
+		d.ingressAddress = opts.IngressAddress
+	}
+
 	d.workerCount = opts.WorkerCount
 	if d.workerCount < 1 {
 		d.workerCount = 10 //default to 10 parallel checks
 	}
 
+	d.createWorkerCount = opts.CreateWorkerCount
+	if d.createWorkerCount < 1 {
+		d.createWorkerCount = d.workerCount
+	}
+
+	d.maxPerSourceNode = opts.MaxPerSourceNode
+	d.maxPerTargetNode = opts.MaxPerTargetNode
+
+	d.retries = opts.Retries
+	if d.retries < 0 {
+		d.retries = 0
+	}
+
+	d.retryBackoff = opts.RetryBackoff
+	if d.retryBackoff <= 0 {
+		d.retryBackoff = RetryBackoff
+	}
+
+	d.resultsDir = opts.ResultsDir
+	d.nodeDiagnostics = opts.NodeDiagnostics
+	if d.nodeDiagnostics && d.resultsDir == "" {
+		fmt.Println("You need to provide a flag -results to store the node diagnostics")
+		os.Exit(1)
+	}
+
+	d.keep = opts.Keep
+	d.podLatency = opts.ReportPodLatency
+
+	d.captures = opts.Captures
+	if d.captures > 0 && d.resultsDir == "" {
+		fmt.Println("You need to provide a flag -results to store the packet captures")
+		os.Exit(1)
+	}
+
+	d.slow = opts.Slow
+	if d.slow <= 0 {
+		d.slow = SlowProbe
+	}
+
 	d.tomb.Go(func() error {
 		if err := d.setup(opts); err != nil {
 			return err
@@ -138,7 +326,7 @@ func (d *Detective) setup(opts Options) error {
 		return err
 	}
 
-	d.createInformers()
+	d.createInformers(opts)
 
 	if err := d.waitForServiceAccountInNamespace(); err != nil {
 		return err
@@ -152,7 +340,17 @@ func (d *Detective) setup(opts Options) error {
 		return err
 	}
 
-	if opts.TestServices || opts.TestServiceName || opts.TestExternalIPs {
+	if opts.ReportPodLatency {
+		if err := d.reportPodLatencies(); err != nil {
+			return err
+		}
+	}
+
+	if err := d.sampleNodePairs(); err != nil {
+		return err
+	}
+
+	if opts.TestServices || opts.TestServiceName || opts.TestExternalIPs || opts.TestIngress {
 		if err := d.createSevices(opts.TestExternalIPs); err != nil {
 			return err
 		}
@@ -162,6 +360,32 @@ func (d *Detective) setup(opts Options) error {
 		}
 	}
 
+	if opts.TestIngress {
+		if err := d.createIngresses(); err != nil {
+			return err
+		}
+
+		if err := d.waitForIngressAddresses(); err != nil {
+			return err
+		}
+	}
+
+	if opts.TestAffinity || opts.TestDistribution {
+		if err := d.createBackendServices(); err != nil {
+			return err
+		}
+
+		if err := d.waitForBackendServiceEndpoints(); err != nil {
+			return err
+		}
+	}
+
+	if opts.TestConvergence {
+		if err := d.createConvergenceService(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -214,10 +438,106 @@ func (d *Detective) execute(opts Options) error {
 		result = multierror.Append(result, d.hitExternalIP(true, true))
 	}
 
+	if opts.UseEndpointSlices {
+		fmt.Println("Service --> Endpoints / EndpointSlices --> Pods")
+		result = multierror.Append(result, d.checkEndpointConsistency())
+	}
+
+	if opts.TestIngress {
+		fmt.Println("Pod --> Ingress --> Pod")
+		result = multierror.Append(result, d.hitIngress(false))
+
+		fmt.Println("Pod (hostNetwork) --> Ingress --> Pod")
+		result = multierror.Append(result, d.hitIngress(true))
+
+		fmt.Println("Detective --> Ingress --> Pod")
+		result = multierror.Append(result, d.hitIngressFromDetective())
+	}
+
+	if opts.TestAffinity {
+		fmt.Println("Pod --> ClusterIP (sessionAffinity: ClientIP) --> Pods")
+		result = multierror.Append(result, d.hitSessionAffinity(false))
+
+		fmt.Println("Pod (hostNetwork) --> ClusterIP (sessionAffinity: ClientIP) --> Pods")
+		result = multierror.Append(result, d.hitSessionAffinity(true))
+
+		fmt.Println("Pod --> ClusterIP (sessionAffinity: None) --> Pods")
+		result = multierror.Append(result, d.hitLoadBalancing(false))
+
+		fmt.Println("Pod (hostNetwork) --> ClusterIP (sessionAffinity: None) --> Pods")
+		result = multierror.Append(result, d.hitLoadBalancing(true))
+	}
+
+	if opts.TestDistribution {
+		fmt.Println("Pod --> ClusterIP --> Pods (all nodes)")
+		result = multierror.Append(result, d.hitLoadDistribution(false))
+
+		fmt.Println("Pod (hostNetwork) --> ClusterIP --> Pods (all nodes)")
+		result = multierror.Append(result, d.hitLoadDistribution(true))
+	}
+
+	if opts.TestConvergence {
+		fmt.Println("Pod --> ClusterIP --> Pod (endpoint change)")
+		result = multierror.Append(result, d.measureConvergence())
+	}
+
+	if opts.TestAPIServer {
+		fmt.Println("Pod --> kubernetes.default --> API Server")
+		result = multierror.Append(result, d.hitAPIServer(false))
+
+		fmt.Println("Pod (hostNetwork) --> kubernetes.default --> API Server")
+		result = multierror.Append(result, d.hitAPIServer(true))
+	}
+
+	if len(d.egressTargets) > 0 {
+		fmt.Println("Pod --> Egress")
+		result = multierror.Append(result, d.hitExternalTargets("Egress", d.egressTargets, false))
+
+		fmt.Println("Pod (hostNetwork) --> Egress")
+		result = multierror.Append(result, d.hitExternalTargets("Egress", d.egressTargets, true))
+	}
+
+	// hostNetwork pods are the node itself, which may reach the forbidden
+	// targets legitimately, e.g. the metadata service or ssh of other nodes
+	if len(d.forbidden) > 0 {
+		fmt.Println("Pod --> Forbidden")
+		result = multierror.Append(result, d.hitExternalTargets("Forbidden", d.forbidden, false))
+	}
+
+	d.printFlaky()
+	d.printFailureClasses()
+	d.printSuspectNodes()
+	d.printLayerDiagnosis()
+
+	reachable := reachability(d.results)
+	printAsymmetricPairs(asymmetricPairs(reachable))
+	printPartitions(connectedComponents(reachable))
+
+	printSecurityFindings(result)
+
+	if opts.Report != "" {
+		result = multierror.Append(result, d.writeReport(opts.Report))
+	}
+
+	if opts.Dot != "" {
+		result = multierror.Append(result, d.writeDot(opts.Dot))
+	}
+
+	if d.resultsDir != "" {
+		result = multierror.Append(result, d.collectBundle())
+	}
+
+	d.printKeepHint()
+
 	return result.ErrorOrNil()
 }
 
 func (d *Detective) cleanup() error {
+	if d.keep {
+		klog.V(2).Infof("Keeping the test namespace")
+		return nil
+	}
+
 	klog.V(2).Infof("Cleaning Up")
 	if d.namespace != nil {
 		return d.deleteNamespace()
@@ -249,12 +569,20 @@ func (d *Detective) createClient(opts Options) error {
 		overrides := &clientcmd.ConfigOverrides{}
 		var err error
 
-		opts.RestConfig, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
 		if err != nil {
 			return err
 		}
 	}
 
+	// client side rate limiting of requests to the API server
+	if opts.QPS > 0 {
+		config.QPS = opts.QPS
+	}
+	if opts.Burst > 0 {
+		config.Burst = opts.Burst
+	}
+
 	client, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return err
@@ -269,7 +597,7 @@ func (d *Detective) createClient(opts Options) error {
 	return nil
 }
 
-func (d *Detective) createInformers() {
+func (d *Detective) createInformers(opts Options) {
 	klog.V(2).Infof("Creating Informers")
 
 	d.informers = informers.NewSharedInformerFactoryWithOptions(d.client, InformerResyncPeriod, informers.WithNamespace(d.namespace.Name))
@@ -278,8 +606,25 @@ func (d *Detective) createInformers() {
 	services := d.informers.Core().V1().Services().Informer()
 	endpoints := d.informers.Core().V1().Endpoints().Informer()
 
+	synced := []cache.InformerSynced{nodes.HasSynced, pods.HasSynced, services.HasSynced, endpoints.HasSynced}
+	if opts.TestIngress {
+		synced = append(synced, d.informers.Networking().V1().Ingresses().Informer().HasSynced)
+	}
+
+	d.podsReady = newReadiness("pods")
+	d.registerPodReadiness(pods)
+
+	d.endpointsReady = newReadiness("endpoints")
+	if opts.UseEndpointSlices {
+		slices := d.informers.Discovery().V1().EndpointSlices().Informer()
+		synced = append(synced, slices.HasSynced)
+		d.registerEndpointSliceReadiness(slices)
+	} else {
+		d.registerEndpointsReadiness(endpoints)
+	}
+
 	d.informers.Start(d.tomb.Dying())
 
 	klog.V(2).Infof("Waiting for Caches")
-	cache.WaitForCacheSync(d.tomb.Dying(), nodes.HasSynced, pods.HasSynced, services.HasSynced, endpoints.HasSynced)
+	cache.WaitForCacheSync(d.tomb.Dying(), synced...)
 }
@@ -0,0 +1,182 @@
+package detective
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// eventHints map substrings of event messages to a likely cause
+var eventHints = []struct {
+	substring string
+	hint      string
+}{
+	{"failed to set up sandbox", "CNI failed to set up the pod network"},
+	{"failed to setup network for sandbox", "CNI failed to set up the pod network"},
+	{"no IP addresses available", "pod IP range exhausted"},
+	{"failed to allocate for range", "pod IP range exhausted"},
+	{"failed to assign an IP address", "pod IP range exhausted"},
+	{"Insufficient", "node resources exhausted"},
+	{"didn't match", "scheduling constraints"},
+}
+
+// podDiagnosis explains why a pod is not running and ready
+func podDiagnosis(pod *core.Pod, events []core.Event) []string {
+	var reasons []string
+
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == core.PodScheduled && cond.Status != core.ConditionTrue {
+			reasons = append(reasons, fmt.Sprintf("not scheduled: %v %v", cond.Reason, cond.Message))
+		}
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil {
+			reasons = append(reasons, fmt.Sprintf("container %v waiting: %v %v", status.Name, status.State.Waiting.Reason, status.State.Waiting.Message))
+		}
+		if status.State.Terminated != nil {
+			reasons = append(reasons, fmt.Sprintf("container %v terminated: %v (exit code %v) %v", status.Name, status.State.Terminated.Reason, status.State.Terminated.ExitCode, status.State.Terminated.Message))
+		}
+		if status.State.Running != nil && !status.Ready {
+			reasons = append(reasons, fmt.Sprintf("container %v running but not ready: readiness probe failing", status.Name))
+		}
+	}
+
+	if pod.Status.Phase == core.PodPending && pod.Status.PodIP == "" && len(pod.Status.ContainerStatuses) == 0 {
+		reasons = append(reasons, "no pod IP assigned yet")
+	}
+
+	for _, event := range events {
+		if event.Type != core.EventTypeWarning {
+			continue
+		}
+		reason := fmt.Sprintf("event %v (x%v): %v", event.Reason, event.Count, strings.TrimSpace(event.Message))
+		for _, h := range eventHints {
+			if strings.Contains(event.Message, h.substring) {
+				reason = fmt.Sprintf("%v [%v]", reason, h.hint)
+				break
+			}
+		}
+		reasons = append(reasons, reason)
+	}
+
+	if len(reasons) == 0 {
+		reasons = append(reasons, fmt.Sprintf("phase %v, no further details", pod.Status.Phase))
+	}
+
+	return reasons
+}
+
+// namespaceEvents returns the events of the test namespace grouped by the
+// name of the involved object
+func (d *Detective) namespaceEvents() (map[string][]core.Event, error) {
+	events, err := d.client.CoreV1().Events(d.namespace.Name).List(d.tomb.Context(nil), meta.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	grouped := map[string][]core.Event{}
+	for _, event := range events.Items {
+		grouped[event.InvolvedObject.Name] = append(grouped[event.InvolvedObject.Name], event)
+	}
+	return grouped, nil
+}
+
+// diagnoseStuckPods prints every test pod which is not running and ready,
+// along with the reasons found in its status and the namespace events. Nodes
+// without any test pod are reported as well.
+func (d *Detective) diagnoseStuckPods() (int, error) {
+	pods, err := d.informers.Core().V1().Pods().Lister().Pods(d.namespace.Name).List(labels.Everything())
+	if err != nil {
+		return 0, err
+	}
+
+	nodes, err := d.ListNodesWithPredicate(d.NodeIsSchedulabeleAndRunning)
+	if err != nil {
+		return 0, err
+	}
+
+	events, err := d.namespaceEvents()
+	if err != nil {
+		return 0, err
+	}
+
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Spec.NodeName < pods[j].Spec.NodeName })
+
+	podsPerNode := map[string]int{}
+	stuck := 0
+	for _, pod := range pods {
+		podsPerNode[pod.Spec.NodeName]++
+
+		if _, ready := podConditionTime(pod, core.PodReady); ready && pod.Status.Phase == core.PodRunning {
+			continue
+		}
+
+		if stuck == 0 {
+			fmt.Println("Stuck Pods")
+		}
+		stuck++
+
+		fmt.Printf("  %v on %v (hostNetwork: %v)\n", pod.Name, pod.Spec.NodeName, pod.Spec.HostNetwork)
+		for _, reason := range podDiagnosis(pod, events[pod.Name]) {
+			fmt.Printf("    %v\n", reason)
+		}
+	}
+
+	for _, node := range nodes {
+		if missing := 2 - podsPerNode[node.Name]; missing > 0 {
+			if stuck == 0 {
+				fmt.Println("Stuck Pods")
+			}
+			stuck += missing
+			fmt.Printf("  %v test pods missing on %v\n", missing, node.Name)
+		}
+	}
+
+	return stuck, nil
+}
+
+// diagnoseMissingEndpoints prints every test service without a ready
+// endpoint and diagnoses the pods behind them
+func (d *Detective) diagnoseMissingEndpoints() error {
+	services, err := d.informers.Core().V1().Services().Lister().Services(d.namespace.Name).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	header := false
+	for _, service := range services {
+		endpoints, err := d.informers.Core().V1().Endpoints().Lister().Endpoints(d.namespace.Name).Get(service.Name)
+
+		ready, notReady := 0, 0
+		if err == nil {
+			for _, subset := range endpoints.Subsets {
+				ready += len(subset.Addresses)
+				notReady += len(subset.NotReadyAddresses)
+			}
+		}
+
+		if ready > 0 || service.Labels["scenario"] == ScenarioConvergence {
+			continue
+		}
+
+		if !header {
+			fmt.Println("Services without Endpoints")
+			header = true
+		}
+
+		switch {
+		case err != nil:
+			fmt.Printf("  %v (%v): no endpoints object: %v\n", service.Name, service.Labels["nodeName"], err)
+		default:
+			fmt.Printf("  %v (%v): %v not ready addresses\n", service.Name, service.Labels["nodeName"], notReady)
+		}
+	}
+
+	_, err = d.diagnoseStuckPods()
+	return err
+}
@@ -0,0 +1,140 @@
+package detective
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	core "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// DistributionRequestsPerBackend is the number of requests per backend a
+// source needs to send so that missing a backend by chance is unlikely
+const DistributionRequestsPerBackend = 10
+
+// hitLoadDistribution sends requests from every source to the balanced
+// service and reports how they were distributed over the backends. Backends
+// which a source never reached hint at incomplete endpoint programming on
+// the source's node.
+func (d *Detective) hitLoadDistribution(sourceHostNetwork bool) error {
+	service, err := d.getBackendService(ScenarioBalanced)
+	if err != nil {
+		return err
+	}
+
+	backendPods, err := d.backendPods()
+	if err != nil {
+		return err
+	}
+
+	total := map[string]int{}
+	for _, pod := range backendPods {
+		total[pod.Name] = 0
+	}
+	var mutex sync.Mutex
+
+	result := multierror.Append(nil, d.hitBackendService(sourceHostNetwork, func(pod *core.Pod) error {
+		responses, err := d.wgetRepeatedly(pod, serviceURL(service), d.distributionRequests)
+		backends := countBackends(responses)
+
+		mutex.Lock()
+		for name, count := range backends {
+			total[name] += count
+		}
+		mutex.Unlock()
+
+		var missing []string
+		for _, backend := range backendPods {
+			if backends[backend.Name] == 0 {
+				missing = append(missing, fmt.Sprintf("%v on %v", backend.Name, backend.Spec.NodeName))
+			}
+		}
+
+		// with too few requests per backend missing some is expected, so
+		// they are only flagged
+		sufficient := d.distributionRequests >= DistributionRequestsPerBackend*len(backendPods)
+
+		class := ""
+		switch {
+		case err != nil:
+		case backends[""] > 0:
+			err = fmt.Errorf("%v of %v requests from %v to %v failed", backends[""], len(responses), pod.Name, service.Name)
+		case len(missing) > 0 && sufficient:
+			err = fmt.Errorf("%v on %v never reached %v via %v", pod.Name, pod.Spec.NodeName, strings.Join(missing, ", "), service.Name)
+			class = ErrorMismatch
+		}
+
+		if err != nil {
+			klog.V(3).Infof("Error: '%s'", err)
+		}
+		result := checkResult("Distribution", pod, "", class, err)
+		reproduce := d.reproduce(&result, pod, wgetRepeatedlyCommand(serviceURL(service), d.distributionRequests))
+
+		details := ""
+		if len(missing) > 0 {
+			details = fmt.Sprintf(", missing %v", summarize(missing, 5))
+			if !sufficient {
+				details += fmt.Sprintf(" (increase -distribution-requests to at least %v)", DistributionRequestsPerBackend*len(backendPods))
+			}
+		}
+
+		fmt.Printf("[%v] %30v --> Distribution %-15v --> %-15v   %v/%v backends, %v failed%v%v\n",
+			result.Status(),
+			pod.Spec.NodeName,
+			pod.Status.PodIP,
+			service.Spec.ClusterIP,
+			len(backendPods)-len(missing),
+			len(backendPods),
+			backends[""],
+			details,
+			reproduce,
+		)
+		d.record(result)
+		return err
+	}))
+
+	for _, backend := range backendPods {
+		if total[backend.Name] == 0 {
+			err := fmt.Errorf("Endpoint %v on %v never received traffic via %v", backend.Name, backend.Spec.NodeName, service.Name)
+			d.record(checkResult("Distribution", nil, backend.Spec.NodeName, ErrorMismatch, err))
+			result = multierror.Append(result, err)
+		}
+	}
+
+	printDistribution(backendPods, total)
+
+	return result.ErrorOrNil()
+}
+
+func printDistribution(backendPods []*core.Pod, total map[string]int) {
+	sum := 0
+	for name, count := range total {
+		if name != "" {
+			sum += count
+		}
+	}
+
+	sorted := make([]*core.Pod, len(backendPods))
+	copy(sorted, backendPods)
+	sort.Slice(sorted, func(i, j int) bool {
+		return total[sorted[i].Name] > total[sorted[j].Name]
+	})
+
+	fmt.Printf("Distribution over %v backends (%v requests, %v failed)\n", len(sorted), sum, total[""])
+	for _, pod := range sorted {
+		share := 0.0
+		if sum > 0 {
+			share = 100 * float64(total[pod.Name]) / float64(sum)
+		}
+
+		marker := ""
+		if total[pod.Name] == 0 {
+			marker = "never received traffic"
+		}
+
+		fmt.Printf("  %30v %-15v %8v %6.2f%% %v\n", pod.Spec.NodeName, pod.Status.PodIP, total[pod.Name], share, marker)
+	}
+}
@@ -0,0 +1,126 @@
+package detective
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SlowProbe is the default duration after which a passing probe is slow. It
+// is well above the usual exec round trip through the API server and the
+// kubelet, which is part of the duration.
+const SlowProbe = 3 * time.Second
+
+// dotColors are assigned to the scenarios in alphabetical order
+var dotColors = []string{"red", "blue", "darkgreen", "orange", "purple", "brown", "magenta", "cyan4", "gold3", "gray40"}
+
+type dotEdge struct {
+	source   string
+	target   string
+	scenario string
+}
+
+type dotStats struct {
+	failed  int
+	slow    int
+	classes map[string]bool
+}
+
+// writeDot writes a Graphviz graph with the nodes as vertices and an edge
+// per scenario with failing or slow probes between them. Failures are solid,
+// slow probes dashed.
+func (d *Detective) writeDot(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return dotGraph(f, d.results, d.slow)
+}
+
+func dotGraph(w io.Writer, results []ProbeResult, slow time.Duration) error {
+	edges := map[dotEdge]*dotStats{}
+	vertices := map[string]bool{}
+	scenarios := map[string]bool{}
+
+	for _, r := range results {
+		failed := r.Outcome == OutcomeFail
+		if !failed && r.Duration < slow {
+			continue
+		}
+
+		source, target := r.Source, r.Target
+		if source == "" {
+			source = "detective"
+		}
+		if target == "" {
+			target = "external"
+		}
+
+		edge := dotEdge{source, target, r.Scenario}
+		if edges[edge] == nil {
+			edges[edge] = &dotStats{classes: map[string]bool{}}
+		}
+		if failed {
+			edges[edge].failed++
+			if r.Class != "" {
+				edges[edge].classes[r.Class] = true
+			}
+		} else {
+			edges[edge].slow++
+		}
+
+		vertices[source] = true
+		vertices[target] = true
+		scenarios[r.Scenario] = true
+	}
+
+	colors := map[string]string{}
+	for i, scenario := range sortedKeys(scenarios) {
+		colors[scenario] = dotColors[i%len(dotColors)]
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph detective {\n")
+	b.WriteString("  node [shape=box];\n")
+	for _, vertex := range sortedKeys(vertices) {
+		fmt.Fprintf(&b, "  %q;\n", vertex)
+	}
+
+	sorted := make([]dotEdge, 0, len(edges))
+	for edge := range edges {
+		sorted = append(sorted, edge)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return fmt.Sprint(sorted[i]) < fmt.Sprint(sorted[j])
+	})
+
+	for _, edge := range sorted {
+		stats := edges[edge]
+		style, label := "dashed", fmt.Sprintf("%v (%v slow)", edge.scenario, stats.slow)
+		if stats.failed > 0 {
+			style, label = "solid", fmt.Sprintf("%v (%v failed)", edge.scenario, stats.failed)
+			if len(stats.classes) > 0 {
+				label = fmt.Sprintf("%v (%v failed: %v)", edge.scenario, stats.failed, strings.Join(sortedKeys(stats.classes), ", "))
+			}
+		}
+		fmt.Fprintf(&b, "  %q -> %q [color=%q, fontcolor=%q, style=%v, label=%q];\n", edge.source, edge.target, colors[edge.scenario], colors[edge.scenario], style, label)
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,332 @@
+package detective
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	core "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	ProtocolTCP   = "tcp"
+	ProtocolHTTP  = "http"
+	ProtocolHTTPS = "https"
+
+	ExpectReachable   = "reachable"
+	ExpectUnreachable = "unreachable"
+
+	// NodesHost expands to the InternalIP of every tested node
+	NodesHost = "@nodes"
+)
+
+// ExternalTarget is an endpoint outside of the test bed which is probed from
+// every test pod. The syntax is `[protocol://]host:port[=expectation]`.
+type ExternalTarget struct {
+	Protocol  string
+	Host      string
+	Port      int32
+	Reachable bool
+}
+
+type ExternalTargetProbe struct {
+	source *core.Pod
+	target ExternalTarget
+}
+
+// SecurityFinding is reported when a pod reaches a target that is expected
+// to be unreachable
+type SecurityFinding struct {
+	Source *core.Pod
+	Target ExternalTarget
+}
+
+func (f *SecurityFinding) Error() string {
+	return fmt.Sprintf("%v on %v reached %v which is expected to be %v", f.Source.Name, f.Source.Spec.NodeName, f.Target, f.Target.expectation())
+}
+
+func (t ExternalTarget) String() string {
+	return fmt.Sprintf("%v://%v", t.Protocol, net.JoinHostPort(t.Host, strconv.Itoa(int(t.Port))))
+}
+
+func (t ExternalTarget) expectation() string {
+	if t.Reachable {
+		return ExpectReachable
+	}
+	return ExpectUnreachable
+}
+
+// ParseExternalTarget parses a single target. reachable is used if the
+// target doesn't specify an expectation itself.
+func ParseExternalTarget(s string, reachable bool) (ExternalTarget, error) {
+	target := ExternalTarget{
+		Protocol:  ProtocolTCP,
+		Reachable: reachable,
+	}
+	input := s
+
+	if i := strings.LastIndex(s, "="); i >= 0 {
+		switch s[i+1:] {
+		case ExpectReachable:
+			target.Reachable = true
+		case ExpectUnreachable:
+			target.Reachable = false
+		default:
+			return target, fmt.Errorf("Invalid expectation %q in target %q", s[i+1:], s)
+		}
+		s = s[:i]
+	}
+
+	if i := strings.Index(s, "://"); i >= 0 {
+		target.Protocol = s[:i]
+		s = s[i+3:]
+	}
+
+	switch target.Protocol {
+	case ProtocolTCP, ProtocolHTTP, ProtocolHTTPS:
+	default:
+		return target, fmt.Errorf("Unsupported protocol %q in target %q", target.Protocol, input)
+	}
+
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return target, fmt.Errorf("Invalid target %q: %v", input, err)
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return target, fmt.Errorf("Invalid port in target %q: %v", input, err)
+	}
+
+	target.Host = host
+	target.Port = int32(p)
+	return target, nil
+}
+
+// ParseExternalTargets parses a comma separated list of targets
+func ParseExternalTargets(list string, reachable bool) ([]ExternalTarget, error) {
+	var targets []ExternalTarget
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		target, err := ParseExternalTarget(s, reachable)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// LoadExternalTargets reads targets from a file with one target per line.
+// Empty lines and lines starting with # are ignored.
+func LoadExternalTargets(path string, reachable bool) ([]ExternalTarget, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var targets []ExternalTarget
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		target, err := ParseExternalTarget(line, reachable)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+
+	return targets, scanner.Err()
+}
+
+func (o *Options) egressTargets() []ExternalTarget {
+	targets, err := ParseExternalTargets(o.EgressTargets, true)
+	if err != nil {
+		fmt.Printf("Couldn't parse -egress: %v\n", err)
+		os.Exit(1)
+	}
+
+	if o.EgressTargetsFile != "" {
+		fromFile, err := LoadExternalTargets(o.EgressTargetsFile, true)
+		if err != nil {
+			fmt.Printf("Couldn't load -egress-file: %v\n", err)
+			os.Exit(1)
+		}
+		targets = append(targets, fromFile...)
+	}
+
+	return targets
+}
+
+func (o *Options) forbiddenTargets() []ExternalTarget {
+	targets, err := ParseExternalTargets(o.ForbiddenTargets, false)
+	if err != nil {
+		fmt.Printf("Couldn't parse -forbidden: %v\n", err)
+		os.Exit(1)
+	}
+
+	if o.ForbiddenTargetsFile != "" {
+		fromFile, err := LoadExternalTargets(o.ForbiddenTargetsFile, false)
+		if err != nil {
+			fmt.Printf("Couldn't load -forbidden-file: %v\n", err)
+			os.Exit(1)
+		}
+		targets = append(targets, fromFile...)
+	}
+
+	return targets
+}
+
+// expandExternalTargets replaces targets pointing to NodesHost with one
+// target per tested node
+func (d *Detective) expandExternalTargets(targets []ExternalTarget) ([]ExternalTarget, error) {
+	nodes, err := d.ListNodesWithPredicate(d.NodeIsSchedulabeleAndRunning)
+	if err != nil {
+		return nil, err
+	}
+
+	var expanded []ExternalTarget
+	for _, target := range targets {
+		if target.Host != NodesHost {
+			expanded = append(expanded, target)
+			continue
+		}
+		for _, node := range nodes {
+			for _, address := range node.Status.Addresses {
+				if address.Type == core.NodeInternalIP {
+					t := target
+					t.Host = address.Address
+					expanded = append(expanded, t)
+				}
+			}
+		}
+	}
+
+	return expanded, nil
+}
+
+func (d *Detective) hitExternalTargets(scenario string, targets []ExternalTarget, sourceHostNetwork bool) error {
+	targets, err := d.expandExternalTargets(targets)
+	if err != nil {
+		return err
+	}
+
+	pods, err := d.listPods(sourceHostNetwork)
+	if err != nil {
+		return err
+	}
+
+	probes := []ExternalTargetProbe{}
+	for _, pod := range pods {
+		for _, target := range targets {
+			probes = append(probes, ExternalTargetProbe{pod, target})
+		}
+	}
+
+	return d.runProbes(len(probes), func(i int) NodePair {
+		return NodePair{Source: probes[i].source.Spec.NodeName}
+	}, func(i int) error {
+		return d.dialExternalTarget(scenario, probes[i].source, probes[i].target)
+	})
+}
+
+func (d *Detective) dialExternalTarget(scenario string, pod *core.Pod, target ExternalTarget) error {
+	command := wgetCommand(target.String())
+	if target.Protocol == ProtocolTCP {
+		command = netcatCommand(target.Host, target.Port)
+	}
+	probe := func() (string, error) {
+		return d.exec(pod, command)
+	}
+
+	// retries only make sense for targets we expect to reach
+	var result ProbeResult
+	if target.Reachable {
+		result = d.retry(probe)
+	} else {
+		result.Output, result.Err = probe()
+		result.Class = errorClass(result.Err)
+		result.Attempts = 1
+	}
+	result.Scenario = scenario
+	result.Source, result.SourceHostNetwork = pod.Spec.NodeName, pod.Spec.HostNetwork
+
+	err := result.Err
+	if err != nil {
+		klog.V(3).Infof("Error: '%s'", err)
+	}
+
+	// the outcome of targets we expect to reach is the one of the probe
+	if !target.Reachable {
+		reached, conclusive := reachedTarget(err)
+		switch {
+		case !conclusive:
+			result.Outcome = OutcomeFail
+		case reached:
+			result.Outcome = OutcomeSecurity
+			err = &SecurityFinding{pod, target}
+		default:
+			result.Outcome = OutcomePass
+			err = nil
+		}
+	}
+	result.Err = err
+
+	reproduce := d.reproduce(&result, pod, command)
+	fmt.Printf("[%v] %30v --> %-15v %-15v --> %-30v (expected %v)%v\n",
+		result.Status(),
+		pod.Spec.NodeName,
+		scenario,
+		pod.Status.PodIP,
+		target,
+		target.expectation(),
+		reproduce,
+	)
+
+	d.record(result)
+
+	return err
+}
+
+// reachedTarget tells by the error of a probe whether the target was reached.
+// Only network failures prove a target is unreachable, exec errors or
+// failures of the tools themselves are inconclusive.
+func reachedTarget(err error) (reached bool, conclusive bool) {
+	switch errorClass(err) {
+	case "", ErrorHTTP, ErrorTLS:
+		return true, true
+	case ErrorTimeout, ErrorRefused, ErrorUnreachable, ErrorDNS, ErrorConnect:
+		return false, true
+	}
+	return false, false
+}
+
+func printSecurityFindings(err *multierror.Error) {
+	var findings []*SecurityFinding
+	for _, e := range err.WrappedErrors() {
+		if f, ok := e.(*SecurityFinding); ok {
+			findings = append(findings, f)
+		}
+	}
+
+	if len(findings) == 0 {
+		return
+	}
+
+	fmt.Printf("Security Findings (%v)\n", len(findings))
+	for _, f := range findings {
+		fmt.Printf("  %v\n", f)
+	}
+}
@@ -0,0 +1,145 @@
+package detective
+
+import (
+	"fmt"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// listEndpointSlices returns the EndpointSlices of a service
+func (d *Detective) listEndpointSlices(service string) ([]*discovery.EndpointSlice, error) {
+	return d.informers.Discovery().V1().EndpointSlices().Lister().EndpointSlices(d.namespace.Name).List(labels.SelectorFromSet(labels.Set{discovery.LabelServiceName: service}))
+}
+
+// endpointSliceAddresses returns the ready addresses of all slices. An
+// endpoint without ready condition is considered ready.
+func endpointSliceAddresses(slices []*discovery.EndpointSlice) sets.String {
+	addresses := sets.NewString()
+	for _, slice := range slices {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			addresses.Insert(endpoint.Addresses...)
+		}
+	}
+	return addresses
+}
+
+func endpointsAddresses(endpoints *core.Endpoints) sets.String {
+	addresses := sets.NewString()
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			addresses.Insert(address.IP)
+		}
+	}
+	return addresses
+}
+
+// registerEndpointSliceReadiness feeds the readiness of endpoints from
+// EndpointSlices instead of the legacy Endpoints. A service may have several
+// slices, so all of them are counted on every change.
+func (d *Detective) registerEndpointSliceReadiness(slices cache.SharedIndexInformer) {
+	service := func(obj interface{}) string {
+		return obj.(*discovery.EndpointSlice).Labels[discovery.LabelServiceName]
+	}
+
+	state := func(obj interface{}) (int, string, error) {
+		slices, err := d.listEndpointSlices(service(obj))
+		if err != nil {
+			return 0, "", err
+		}
+		return endpointSliceAddresses(slices).Len(), fmt.Sprintf("addresses ready in %v slices", len(slices)), nil
+	}
+
+	handler := readinessHandler(d.endpointsReady, service, state)
+	// deleting one of several slices doesn't remove the service
+	handler.DeleteFunc = func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		handler.AddFunc(obj)
+	}
+
+	slices.AddEventHandler(handler)
+}
+
+// checkEndpointConsistency compares for every test service the addresses
+// published in EndpointSlices and Endpoints with the IPs of the ready pods
+// selected by the service
+func (d *Detective) checkEndpointConsistency() error {
+	services, err := d.informers.Core().V1().Services().Lister().Services(d.namespace.Name).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	return d.runProbes(len(services), func(i int) NodePair {
+		return NodePair{}
+	}, func(i int) error {
+		return d.compareEndpoints(services[i])
+	})
+}
+
+func (d *Detective) compareEndpoints(service *core.Service) error {
+	pods, err := d.informers.Core().V1().Pods().Lister().Pods(d.namespace.Name).List(labels.SelectorFromSet(service.Spec.Selector))
+	if err != nil {
+		return err
+	}
+
+	selected := sets.NewString()
+	for _, pod := range pods {
+		if _, ready := podConditionTime(pod, core.PodReady); ready && pod.Status.PodIP != "" {
+			selected.Insert(pod.Status.PodIP)
+		}
+	}
+
+	published := sets.NewString()
+	if endpoints, err := d.informers.Core().V1().Endpoints().Lister().Endpoints(d.namespace.Name).Get(service.Name); err == nil {
+		published = endpointsAddresses(endpoints)
+	}
+
+	slices, err := d.listEndpointSlices(service.Name)
+	if err != nil {
+		return err
+	}
+	sliced := endpointSliceAddresses(slices)
+
+	var mismatches []string
+	mismatches = append(mismatches, describeMismatch("Endpoints", published, selected)...)
+	mismatches = append(mismatches, describeMismatch("EndpointSlices", sliced, selected)...)
+
+	if len(mismatches) > 0 {
+		err = fmt.Errorf("Endpoints of %v are inconsistent: %v", service.Name, strings.Join(mismatches, "; "))
+		klog.V(3).Infof("Error: '%s'", err)
+	}
+
+	result := checkResult("EndpointSlice", nil, service.Labels["nodeName"], ErrorMismatch, err)
+	fmt.Printf("[%v] %-30v pods %-3v endpoints %-3v slices %-3v (%v)   %v\n",
+		result.Status(),
+		service.Name,
+		selected.Len(),
+		published.Len(),
+		sliced.Len(),
+		len(slices),
+		strings.Join(mismatches, "; "),
+	)
+	d.record(result)
+	return err
+}
+
+func describeMismatch(kind string, published, selected sets.String) []string {
+	var mismatches []string
+	if missing := selected.Difference(published); missing.Len() > 0 {
+		mismatches = append(mismatches, fmt.Sprintf("%v missing %v", kind, missing.List()))
+	}
+	if stale := published.Difference(selected); stale.Len() > 0 {
+		mismatches = append(mismatches, fmt.Sprintf("%v stale %v", kind, stale.List()))
+	}
+	return mismatches
+}
@@ -0,0 +1,113 @@
+package detective
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+const (
+	ErrorExec        = "exec"
+	ErrorTimeout     = "timeout"
+	ErrorRefused     = "refused"
+	ErrorUnreachable = "unreachable"
+	ErrorDNS         = "dns"
+	ErrorTLS         = "tls"
+	ErrorHTTP        = "http"
+	ErrorConnect     = "connect"
+	ErrorExit        = "exit"
+	ErrorMismatch    = "mismatch"
+	ErrorOther       = "other"
+)
+
+// ProbeError is a failed probe classified by its cause. Exec errors mean the
+// command never ran or its result was lost, e.g. because the kubelet is not
+// reachable. They don't tell anything about the network under test.
+type ProbeError struct {
+	Class    string
+	ExitCode int
+	Output   string
+	Err      error
+}
+
+func (e *ProbeError) Error() string {
+	if e.ExitCode != 0 {
+		return fmt.Sprintf("%v: exit code %v: %v", e.Class, e.ExitCode, e.Output)
+	}
+	return fmt.Sprintf("%v: %v", e.Class, e.Err)
+}
+
+func (e *ProbeError) Unwrap() error {
+	return e.Err
+}
+
+// outputClasses maps messages of busybox and GNU wget, nc and curl to classes
+var outputClasses = []struct {
+	message string
+	class   string
+}{
+	{"timed out", ErrorTimeout},
+	{"Connection refused", ErrorRefused},
+	{"No route to host", ErrorUnreachable},
+	{"Network is unreachable", ErrorUnreachable},
+	{"bad address", ErrorDNS},
+	{"unable to resolve host", ErrorDNS},
+	{"Could not resolve host", ErrorDNS},
+	{"SSL certificate problem", ErrorTLS},
+	{"server returned error", ErrorHTTP},
+	{"ERROR ", ErrorHTTP},
+}
+
+// classifyExec classifies the error of an exec by whether the command ran
+// and exited non-zero, and if so by its output on stderr
+func classifyExec(err error, command []string, stderr string) error {
+	if err == nil {
+		return nil
+	}
+
+	var exit utilexec.ExitError
+	if !errors.As(err, &exit) {
+		return &ProbeError{Class: ErrorExec, Err: err}
+	}
+
+	// nc -z fails silently if it can't connect
+	class := ErrorExit
+	if strings.TrimSpace(stderr) == "" && len(command) > 0 && command[0] == "nc" {
+		class = ErrorConnect
+	}
+	for _, c := range outputClasses {
+		if strings.Contains(stderr, c.message) {
+			class = c.class
+			break
+		}
+	}
+
+	return &ProbeError{Class: class, ExitCode: exit.ExitStatus(), Output: strings.TrimSpace(stderr), Err: err}
+}
+
+// errorClass returns the class of err, which is empty for nil
+func errorClass(err error) string {
+	var probeErr *ProbeError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &probeErr):
+		return probeErr.Class
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorRefused
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return ErrorUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	}
+	return ErrorOther
+}
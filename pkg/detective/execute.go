@@ -1,12 +1,17 @@
 package detective
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
@@ -22,244 +27,367 @@ type PodTarget struct {
 	target *core.Pod
 }
 
-func (d *Detective) hitServices(sourceHostNetwork, targetHostNetwork bool) error {
-	services, err := d.informers.Core().V1().Services().Lister().Services(d.namespace.Name).List(labels.Everything())
+// listPods lists the test pods with or without hostNetwork
+func (d *Detective) listPods(hostNetwork bool) ([]*core.Pod, error) {
+	return d.informers.Core().V1().Pods().Lister().Pods(d.namespace.Name).List(labels.SelectorFromSet(labels.Set{"hostNetwork": strconv.FormatBool(hostNetwork)}))
+}
+
+// serviceTargets enumerates the sampled pairs of source pods and services
+// of a scenario
+func (d *Detective) serviceTargets(sourceHostNetwork, targetHostNetwork bool) ([]ServiceTarget, error) {
+	services, err := d.ListPodServices()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	pods, err := d.informers.Core().V1().Pods().Lister().Pods(d.namespace.Name).List(labels.Everything())
+	pods, err := d.listPods(sourceHostNetwork)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	targets := []ServiceTarget{}
 	for _, service := range services {
+		if service.Labels["hostNetwork"] != strconv.FormatBool(targetHostNetwork) {
+			continue
+		}
 		for _, pod := range pods {
 			if !d.tomb.Alive() {
-				return fmt.Errorf("Interrupted")
+				return nil, fmt.Errorf("Interrupted")
 			}
-			targets = append(targets, ServiceTarget{pod, service})
-		}
-	}
-
-	var result *multierror.Error
-	var mutex sync.Mutex
-
-	workqueue.ParallelizeUntil(d.tomb.Context(nil), d.workerCount, len(services)*len(pods), func(i int) {
-		pod := targets[i].source
-		service := targets[i].target
-		if sourceHostNetwork == pod.Spec.HostNetwork {
-			if s, err := strconv.ParseBool(service.Labels["hostNetwork"]); err == nil && targetHostNetwork == s {
-				err := d.dialClusterIP(pod, service)
-				mutex.Lock()
-				result = multierror.Append(result, err)
-				mutex.Unlock()
+			if d.sampled(pod.Spec.NodeName, service.Labels["nodeName"]) {
+				targets = append(targets, ServiceTarget{pod, service})
 			}
 		}
-	})
+	}
 
-	return result.ErrorOrNil()
+	return targets, nil
 }
 
-func (d *Detective) hitServiceName() error {
-	services, err := d.informers.Core().V1().Services().Lister().Services(d.namespace.Name).List(labels.Everything())
+// podTargets enumerates the sampled pairs of source and target pods of a
+// scenario
+func (d *Detective) podTargets(sourceHostNetwork, targetHostNetwork bool) ([]PodTarget, error) {
+	sources, err := d.listPods(sourceHostNetwork)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	pods, err := d.informers.Core().V1().Pods().Lister().Pods(d.namespace.Name).List(labels.Everything())
+	pods, err := d.listPods(targetHostNetwork)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	targets := []ServiceTarget{}
-	//for each pod we test a single service name resolution
-	for _, pod := range pods {
-		if !d.tomb.Alive() {
-			return fmt.Errorf("Interrupted")
-		}
-		if pod.Spec.HostNetwork { // skip host networking pods
-			continue
+	targets := []PodTarget{}
+	for _, source := range sources {
+		for _, target := range pods {
+			if !d.tomb.Alive() {
+				return nil, fmt.Errorf("Interrupted")
+			}
+			if d.sampled(source.Spec.NodeName, target.Spec.NodeName) {
+				targets = append(targets, PodTarget{source, target})
+			}
 		}
-		targets = append(targets, ServiceTarget{pod, services[0]})
 	}
 
+	return targets, nil
+}
+
+// runProbes runs probe for each of n targets with workerCount workers. The
+// scheduler limits the probes in flight per node of the pairs returned by
+// pair. Errors are aggregated and the progress is logged periodically. The
+// captures of failures are run once all probes are done.
+func (d *Detective) runProbes(n int, pair func(i int) NodePair, probe func(i int) error) error {
+	ctx, cancel := context.WithCancel(d.tomb.Context(nil))
+	defer cancel()
 	var result *multierror.Error
 	var mutex sync.Mutex
 
-	workqueue.ParallelizeUntil(d.tomb.Context(nil), d.workerCount, len(targets), func(i int) {
-		pod := targets[i].source
-		service := targets[i].target
-		err := d.dialServiceDNS(pod, service)
+	s := newScheduler(n, pair, d.maxPerSourceNode, d.maxPerTargetNode)
+	go s.wakeup(ctx)
+
+	klog.V(2).Infof("  running %v probes", n)
+	start := time.Now()
+	reported := start
+	done := 0
+
+	workqueue.ParallelizeUntil(ctx, d.workerCount, n, func(_ int) {
+		i, ok := s.next(ctx)
+		if !ok {
+			return
+		}
+		err := probe(i)
+		s.done(i)
+
 		mutex.Lock()
 		result = multierror.Append(result, err)
+		done++
+		if time.Since(reported) > WaitForEndpointInterval {
+			reported = time.Now()
+			eta := time.Since(start) / time.Duration(done) * time.Duration(n-done)
+			klog.V(2).Infof("  %v/%v probes done, ETA %v", done, n, eta.Round(time.Second))
+		}
 		mutex.Unlock()
 	})
+	d.runCaptures()
 
-	return result.ErrorOrNil()
+	return multierror.Append(result, ctx.Err()).ErrorOrNil()
 }
 
-func (d *Detective) hitExternalIP(sourceHostNetwork, targetHostNetwork bool) error {
-	services, err := d.informers.Core().V1().Services().Lister().Services(d.namespace.Name).List(labels.Everything())
+func (d *Detective) hitServices(sourceHostNetwork, targetHostNetwork bool) error {
+	targets, err := d.serviceTargets(sourceHostNetwork, targetHostNetwork)
 	if err != nil {
 		return err
 	}
 
-	pods, err := d.informers.Core().V1().Pods().Lister().Pods(d.namespace.Name).List(labels.Everything())
+	return d.runProbes(len(targets), func(i int) NodePair {
+		return NodePair{targets[i].source.Spec.NodeName, targets[i].target.Labels["nodeName"]}
+	}, func(i int) error {
+		return d.dialClusterIP(targets[i].source, targets[i].target)
+	})
+}
+
+func (d *Detective) hitServiceName() error {
+	services, err := d.ListPodServices()
 	if err != nil {
 		return err
 	}
 
-	targets := []ServiceTarget{}
-	for _, service := range services {
-		for _, pod := range pods {
-			if !d.tomb.Alive() {
-				return fmt.Errorf("Interrupted")
-			}
-			targets = append(targets, ServiceTarget{pod, service})
-		}
+	// skip host networking pods
+	pods, err := d.listPods(false)
+	if err != nil {
+		return err
 	}
 
-	ctx := d.tomb.Context(nil)
+	//for each pod we test a single service name resolution
+	return d.runProbes(len(pods), func(i int) NodePair {
+		return NodePair{pods[i].Spec.NodeName, services[0].Labels["nodeName"]}
+	}, func(i int) error {
+		return d.dialServiceDNS(pods[i], services[0])
+	})
+}
 
-	var result *multierror.Error
-	var mutex sync.Mutex
+func (d *Detective) hitExternalIP(sourceHostNetwork, targetHostNetwork bool) error {
+	targets, err := d.serviceTargets(sourceHostNetwork, targetHostNetwork)
+	if err != nil {
+		return err
+	}
 
-	workqueue.ParallelizeUntil(ctx, d.workerCount, len(services)*len(pods), func(i int) {
-		pod := targets[i].source
-		service := targets[i].target
-		if sourceHostNetwork == pod.Spec.HostNetwork {
-			if s, err := strconv.ParseBool(service.Labels["hostNetwork"]); err == nil && targetHostNetwork == s {
-				err := d.dialExternalIP(pod, service)
-				mutex.Lock()
-				result = multierror.Append(result, err)
-				mutex.Unlock()
-			}
-		}
+	return d.runProbes(len(targets), func(i int) NodePair {
+		return NodePair{targets[i].source.Spec.NodeName, targets[i].target.Labels["nodeName"]}
+	}, func(i int) error {
+		return d.dialExternalIP(targets[i].source, targets[i].target)
 	})
-
-	return multierror.Append(result, ctx.Err()).ErrorOrNil()
 }
 
 func (d *Detective) hitPods(sourceHostNetwork, targetHostNetwork bool) error {
-	pods, err := d.informers.Core().V1().Pods().Lister().Pods(d.namespace.Name).List(labels.Everything())
+	targets, err := d.podTargets(sourceHostNetwork, targetHostNetwork)
 	if err != nil {
 		return err
 	}
 
-	targets := []PodTarget{}
-	for _, source := range pods {
-		for _, target := range pods {
-			if !d.tomb.Alive() {
-				return fmt.Errorf("Interrupted")
-			}
-			targets = append(targets, PodTarget{source, target})
-		}
+	return d.runProbes(len(targets), func(i int) NodePair {
+		return NodePair{targets[i].source.Spec.NodeName, targets[i].target.Spec.NodeName}
+	}, func(i int) error {
+		return d.dialPodIP(targets[i].source, targets[i].target)
+	})
+}
+
+func (d *Detective) hitAPIServer(sourceHostNetwork bool) error {
+	service, err := d.client.CoreV1().Services(meta.NamespaceDefault).Get(d.tomb.Context(nil), "kubernetes", meta.GetOptions{})
+	if err != nil {
+		return err
 	}
 
-	ctx := d.tomb.Context(nil)
-	var result *multierror.Error
-	var mutex sync.Mutex
+	pods, err := d.listPods(sourceHostNetwork)
+	if err != nil {
+		return err
+	}
 
-	workqueue.ParallelizeUntil(ctx, d.workerCount, len(pods)*len(pods), func(i int) {
-		source := targets[i].source
-		target := targets[i].target
-		if sourceHostNetwork == source.Spec.HostNetwork && targetHostNetwork == target.Spec.HostNetwork {
-			err := d.dialPodIP(source, target)
-			mutex.Lock()
-			result = multierror.Append(result, err)
-			mutex.Unlock()
-		}
+	return d.runProbes(len(pods), func(i int) NodePair {
+		return NodePair{Source: pods[i].Spec.NodeName}
+	}, func(i int) error {
+		errClusterIP := d.dialAPIServer(pods[i], service.Spec.ClusterIP, service.Spec.Ports[0].Port)
+		errServiceName := d.dialAPIServer(pods[i], "kubernetes.default.svc", service.Spec.Ports[0].Port)
+		return multierror.Append(errClusterIP, errServiceName).ErrorOrNil()
 	})
-
-	return multierror.Append(result, ctx.Err()).ErrorOrNil()
 }
 
 func (d *Detective) dialPodIP(source *core.Pod, target *core.Pod) error {
-	_, err := d.dial(source, target.Status.PodIP, PodHttpPort)
-
-	result := "success"
-	if err != nil {
-		klog.V(3).Infof("Error: '%v'", err)
-		result = "failure"
+	command := dialCommand(target.Status.PodIP, PodHttpPort)
+	probe := func() (string, error) {
+		return d.exec(source, command)
+	}
+	result := d.retry(probe)
+	result.Scenario = "Pod"
+	result.Source, result.SourceHostNetwork = source.Spec.NodeName, source.Spec.HostNetwork
+	result.Target, result.TargetHostNetwork = target.Spec.NodeName, target.Spec.HostNetwork
+	if result.Err != nil {
+		klog.V(3).Infof("Error: '%v'", result.Err)
 	}
 
-	fmt.Printf("[%v] %30v --> %-30v   %-15v --> %-15v\n",
-		result,
+	reproduce := d.reproduce(&result, source, command)
+	fmt.Printf("[%v] %30v --> %-30v   %-15v --> %-15v%v\n",
+		result.Status(),
 		source.Spec.NodeName,
 		target.Spec.NodeName,
 		source.Status.PodIP,
 		target.Status.PodIP,
+		reproduce,
 	)
-	return err
+
+	index := d.record(result)
+	d.queueCapture(result, index, fmt.Sprintf("host %v and host %v and tcp port %v", source.Status.PodIP, target.Status.PodIP, PodHttpPort), probe)
+	return result.Err
 }
 
 func (d *Detective) dialClusterIP(pod *core.Pod, service *core.Service) error {
-	_, err := d.dial(pod, service.Spec.ClusterIP, service.Spec.Ports[0].Port)
-
-	result := "success"
-	if err != nil {
-		klog.V(3).Infof("Error: '%s'", err)
-
-		result = "failure"
+	command := dialCommand(service.Spec.ClusterIP, service.Spec.Ports[0].Port)
+	probe := func() (string, error) {
+		return d.exec(pod, command)
+	}
+	result := d.retry(probe)
+	result.Scenario = "ClusterIP"
+	result.Source, result.SourceHostNetwork = pod.Spec.NodeName, pod.Spec.HostNetwork
+	result.Target, result.TargetHostNetwork = service.Labels["nodeName"], service.Labels["hostNetwork"] == "true"
+	if result.Err != nil {
+		klog.V(3).Infof("Error: '%s'", result.Err)
 	}
 
-	fmt.Printf("[%v] %30v --> ClusterIP --> %-30v   %-15v --> %-15v --> %-15v\n",
-		result,
+	reproduce := d.reproduce(&result, pod, command)
+	fmt.Printf("[%v] %30v --> ClusterIP --> %-30v   %-15v --> %-15v --> %-15v%v\n",
+		result.Status(),
 		pod.Spec.NodeName,
 		service.Labels["nodeName"],
 		pod.Status.PodIP,
 		service.Spec.ClusterIP,
 		service.Labels["podIP"],
+		reproduce,
 	)
-	return err
+
+	index := d.record(result)
+	d.queueCapture(result, index, fmt.Sprintf("host %v and (host %v or host %v) and tcp", pod.Status.PodIP, service.Spec.ClusterIP, service.Labels["podIP"]), probe)
+	return result.Err
 }
 
 func (d *Detective) dialServiceDNS(pod *core.Pod, service *core.Service) error {
-	_, err := d.dial(pod, service.Name, service.Spec.Ports[0].Port)
-
-	result := "success"
-	if err != nil {
-		klog.V(3).Infof("Error: '%s'", err)
+	command := dialCommand(service.Name, service.Spec.Ports[0].Port)
+	result := d.retry(func() (string, error) {
+		return d.exec(pod, command)
+	})
+	result.Scenario = "Service Name"
+	result.Source, result.SourceHostNetwork = pod.Spec.NodeName, pod.Spec.HostNetwork
+	result.Target, result.TargetHostNetwork = service.Labels["nodeName"], service.Labels["hostNetwork"] == "true"
 
-		result = "failure"
+	if result.Err != nil {
+		klog.V(3).Infof("Error: '%s'", result.Err)
 	}
 
-	fmt.Printf("[%v] %30v --> Service Name    %-15v --> %-15v --> %-15v\n",
-		result,
+	reproduce := d.reproduce(&result, pod, command)
+	fmt.Printf("[%v] %30v --> Service Name    %-15v --> %-15v --> %-15v%v\n",
+		result.Status(),
 		pod.Spec.NodeName,
 		pod.Status.PodIP,
 		service.Name,
 		service.Labels["podIP"],
+		reproduce,
 	)
-	return err
+
+	d.record(result)
+	return result.Err
 }
 
 func (d *Detective) dialExternalIP(pod *core.Pod, service *core.Service) error {
-	_, err := d.dial(pod, service.Spec.ExternalIPs[0], service.Spec.Ports[0].Port)
-
-	result := "success"
-	if err != nil {
-		klog.V(3).Infof("Error: '%s'", err)
-
-		result = "failure"
+	command := dialCommand(service.Spec.ExternalIPs[0], service.Spec.Ports[0].Port)
+	probe := func() (string, error) {
+		return d.exec(pod, command)
+	}
+	result := d.retry(probe)
+	result.Scenario = "ExternalIP"
+	result.Source, result.SourceHostNetwork = pod.Spec.NodeName, pod.Spec.HostNetwork
+	result.Target, result.TargetHostNetwork = service.Labels["nodeName"], service.Labels["hostNetwork"] == "true"
+	if result.Err != nil {
+		klog.V(3).Infof("Error: '%s'", result.Err)
 	}
 
-	fmt.Printf("[%v] %30v --> ExternalIP --> %-30v   %-15v --> %-15v --> %-15v\n",
-		result,
+	reproduce := d.reproduce(&result, pod, command)
+	fmt.Printf("[%v] %30v --> ExternalIP --> %-30v   %-15v --> %-15v --> %-15v%v\n",
+		result.Status(),
 		pod.Spec.NodeName,
 		service.Labels["nodeName"],
 		pod.Status.PodIP,
 		service.Spec.ExternalIPs[0],
 		service.Labels["podIP"],
+		reproduce,
+	)
+
+	index := d.record(result)
+	d.queueCapture(result, index, fmt.Sprintf("host %v and (host %v or host %v) and tcp", pod.Status.PodIP, service.Spec.ExternalIPs[0], service.Labels["podIP"]), probe)
+	return result.Err
+}
+
+// dialAPIServer validates the TLS handshake against the API server using the
+// cluster CA mounted into every pod with the default service account. The
+// BusyBox wget can't verify certificates, so this requires curl in the test
+// image.
+func (d *Detective) dialAPIServer(pod *core.Pod, host string, port int32) error {
+	url := fmt.Sprintf("https://%v/healthz", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	command := []string{"curl", "--silent", "--show-error", "--max-time", "10", "--cacert", ServiceAccountCACert, "--output", "/dev/null", "--write-out", "%{http_code}", url}
+	result := d.retry(func() (string, error) {
+		response, err := d.exec(pod, command)
+		if err == nil {
+			err = checkAPIServerResponse(response)
+		}
+		return response, err
+	})
+	result.Scenario = "API Server"
+	result.Source, result.SourceHostNetwork = pod.Spec.NodeName, pod.Spec.HostNetwork
+
+	if result.Err != nil {
+		klog.V(3).Infof("Error: '%s'", result.Err)
+	}
+
+	reproduce := d.reproduce(&result, pod, command)
+	fmt.Printf("[%v] %30v --> API Server      %-15v --> %-15v%v\n",
+		result.Status(),
+		pod.Spec.NodeName,
+		pod.Status.PodIP,
+		url,
+		reproduce,
 	)
-	return err
+
+	d.record(result)
+	return result.Err
+}
+
+// checkAPIServerResponse accepts any response of the API server which proves
+// the connection works. Without anonymous auth /healthz is unauthorized.
+func checkAPIServerResponse(statusCode string) error {
+	switch strings.TrimSpace(statusCode) {
+	case "200", "401", "403":
+		return nil
+	}
+	return &ProbeError{Class: ErrorHTTP, Err: fmt.Errorf("API server responded with HTTP status %q", strings.TrimSpace(statusCode))}
 }
 
 func (d *Detective) dial(pod *core.Pod, host string, port int32) (string, error) {
+	return d.exec(pod, dialCommand(host, port))
+}
+
+func dialCommand(host string, port int32) []string {
+	return wgetCommand(fmt.Sprintf("http://%v:%v", host, port))
+}
+
+func netcatCommand(host string, port int32) []string {
+	return []string{"nc", "-z", "-w", "10", host, strconv.Itoa(int(port))}
+}
+
+func wgetCommand(url string, args ...string) []string {
+	command := append([]string{"wget", "--timeout=10"}, args...)
+	return append(command, "-O-", url)
+}
+
+func (d *Detective) exec(pod *core.Pod, command []string) (string, error) {
 	stdout, stderr, err := d.ExecWithOptions(ExecOptions{
-		Command:            []string{"wget", "--timeout=10", "-O-", fmt.Sprintf("http://%v:%v", host, port)},
+		Command:            command,
 		Namespace:          d.namespace.Name,
 		PodName:            pod.Name,
 		ContainerName:      "server",
@@ -267,5 +395,5 @@ func (d *Detective) dial(pod *core.Pod, host string, port int32) (string, error)
 		CaptureStdout:      true,
 		PreserveWhitespace: true,
 	})
-	return stdout + stderr, err
+	return stdout + stderr, classifyExec(err, command, stderr)
 }
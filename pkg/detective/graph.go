@@ -0,0 +1,111 @@
+package detective
+
+import (
+	"fmt"
+	"sort"
+)
+
+// reachability returns for each tested pod to pod pair over the pod network
+// whether it passed. Flaky pairs passed. Exec errors tell nothing about the
+// network and leave the pair untested.
+func reachability(results []ProbeResult) map[NodePair]bool {
+	reachable := map[NodePair]bool{}
+	for _, r := range results {
+		if r.Scenario != "Pod" || r.SourceHostNetwork || r.TargetHostNetwork {
+			continue
+		}
+		if r.Class == ErrorExec && r.Outcome == OutcomeFail {
+			continue
+		}
+		reachable[NodePair{r.Source, r.Target}] = r.Outcome != OutcomeFail
+	}
+	return reachable
+}
+
+// asymmetricPairs returns the pairs which work in one direction only. The
+// pair is directed in the working direction.
+func asymmetricPairs(reachable map[NodePair]bool) []NodePair {
+	var pairs []NodePair
+	for pair, ok := range reachable {
+		back, tested := reachable[NodePair{pair.Target, pair.Source}]
+		if ok && tested && !back {
+			pairs = append(pairs, pair)
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Source != pairs[j].Source {
+			return pairs[i].Source < pairs[j].Source
+		}
+		return pairs[i].Target < pairs[j].Target
+	})
+	return pairs
+}
+
+// connectedComponents groups the nodes which reach each other in at least
+// one direction, directly or via other nodes. More than one component
+// reveals a network partition.
+func connectedComponents(reachable map[NodePair]bool) [][]string {
+	parent := map[string]string{}
+	var find func(node string) string
+	find = func(node string) string {
+		if parent[node] == node {
+			return node
+		}
+		parent[node] = find(parent[node])
+		return parent[node]
+	}
+
+	for pair := range reachable {
+		parent[pair.Source] = pair.Source
+		parent[pair.Target] = pair.Target
+	}
+	for pair, ok := range reachable {
+		if ok {
+			parent[find(pair.Source)] = find(pair.Target)
+		}
+	}
+
+	groups := map[string][]string{}
+	for node := range parent {
+		root := find(node)
+		groups[root] = append(groups[root], node)
+	}
+
+	components := make([][]string, 0, len(groups))
+	for _, nodes := range groups {
+		sort.Strings(nodes)
+		components = append(components, nodes)
+	}
+
+	// largest first
+	sort.Slice(components, func(i, j int) bool {
+		if len(components[i]) != len(components[j]) {
+			return len(components[i]) > len(components[j])
+		}
+		return components[i][0] < components[j][0]
+	})
+	return components
+}
+
+func printAsymmetricPairs(pairs []NodePair) {
+	if len(pairs) == 0 {
+		return
+	}
+
+	fmt.Printf("Asymmetric Pairs (%v)\n", len(pairs))
+	for _, pair := range pairs {
+		fmt.Printf("  %30v --> %-30v works, the reverse fails\n", pair.Source, pair.Target)
+	}
+}
+
+func printPartitions(components [][]string) {
+	if len(components) < 2 {
+		return
+	}
+
+	fmt.Printf("Partitions (%v)\n", len(components))
+	for i, nodes := range components {
+		fmt.Printf("  %v: %v nodes: %v\n", i+1, len(nodes), summarize(nodes, 5))
+	}
+}
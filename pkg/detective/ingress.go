@@ -0,0 +1,365 @@
+package detective
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	IngressRoutingHost = "host"
+	IngressRoutingPath = "path"
+
+	// IngressNodeAddress probes the ingress controller on the node of the
+	// source pod. Useful for controllers running as DaemonSet.
+	IngressNodeAddress = "@node"
+)
+
+type IngressTarget struct {
+	source *core.Pod
+	target *networking.Ingress
+}
+
+func (d *Detective) createIngresses() error {
+	klog.V(2).Info("Creating ingresses")
+
+	services, err := d.ListPodServices()
+	if err != nil {
+		return err
+	}
+
+	return d.createInParallel(len(services), func(i int) error {
+		ingress, err := d.client.NetworkingV1().Ingresses(d.namespace.Name).Create(d.tomb.Context(nil), d.createIngressSpec(services[i]), meta.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to create ingress for %v: %v", services[i].Name, err)
+		}
+		klog.V(3).Infof("  created %v for %v", ingress.Name, services[i].Name)
+		return nil
+	})
+}
+
+func (d *Detective) createIngressSpec(service *core.Service) *networking.Ingress {
+	pathType := networking.PathTypePrefix
+
+	ingressLabels := map[string]string{"serviceName": service.Name}
+	for k, v := range service.Labels {
+		ingressLabels[k] = v
+	}
+
+	ingress := &networking.Ingress{
+		ObjectMeta: meta.ObjectMeta{
+			GenerateName: "ingress-",
+			Labels:       ingressLabels,
+		},
+		Spec: networking.IngressSpec{
+			Rules: []networking.IngressRule{
+				{
+					IngressRuleValue: networking.IngressRuleValue{
+						HTTP: &networking.HTTPIngressRuleValue{
+							Paths: []networking.HTTPIngressPath{
+								{
+									Path:     "/",
+									PathType: &pathType,
+									Backend: networking.IngressBackend{
+										Service: &networking.IngressServiceBackend{
+											Name: service.Name,
+											Port: networking.ServiceBackendPort{Number: ServiceHttpPort},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if d.ingressClass != "" {
+		ingress.Spec.IngressClassName = &d.ingressClass
+	}
+
+	switch d.ingressRouting {
+	case IngressRoutingHost:
+		ingress.Spec.Rules[0].Host = fmt.Sprintf("%v.%v", service.Name, d.ingressDomain)
+	default:
+		ingress.Spec.Rules[0].HTTP.Paths[0].Path = "/" + service.Name
+	}
+
+	return ingress
+}
+
+func (d *Detective) waitForIngressAddresses() error {
+	if d.ingressAddress != "" {
+		return nil
+	}
+
+	klog.V(2).Info("Waiting for ingress addresses")
+
+	err := d.pollWithTimeout(d.endpointsTimeout, func() (done bool, err error) {
+		ingresses, err := d.informers.Networking().V1().Ingresses().Lister().Ingresses(d.namespace.Name).List(labels.Everything())
+		if err != nil {
+			return false, err
+		}
+
+		ready := 0
+		for _, ingress := range ingresses {
+			if len(ingress.Status.LoadBalancer.Ingress) > 0 {
+				ready++
+			}
+		}
+
+		klog.V(3).Infof("  %v/%v ingresses have an address", ready, len(ingresses))
+		return ready == len(ingresses), nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("Ingresses got no address within %v. Is an ingress controller for class %q running?", d.endpointsTimeout, d.ingressClass)
+	}
+	return err
+}
+
+func (d *Detective) hitIngress(sourceHostNetwork bool) error {
+	ingresses, err := d.informers.Networking().V1().Ingresses().Lister().Ingresses(d.namespace.Name).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	pods, err := d.listPods(sourceHostNetwork)
+	if err != nil {
+		return err
+	}
+
+	targets := []IngressTarget{}
+	for _, ingress := range ingresses {
+		for _, pod := range pods {
+			if !d.tomb.Alive() {
+				return fmt.Errorf("Interrupted")
+			}
+			if d.sampled(pod.Spec.NodeName, ingress.Labels["nodeName"]) {
+				targets = append(targets, IngressTarget{pod, ingress})
+			}
+		}
+	}
+
+	return d.runProbes(len(targets), func(i int) NodePair {
+		return NodePair{targets[i].source.Spec.NodeName, targets[i].target.Labels["nodeName"]}
+	}, func(i int) error {
+		return d.dialIngress(targets[i].source, targets[i].target)
+	})
+}
+
+func (d *Detective) hitIngressFromDetective() error {
+	ingresses, err := d.informers.Networking().V1().Ingresses().Lister().Ingresses(d.namespace.Name).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	return d.runProbes(len(ingresses), func(i int) NodePair {
+		return NodePair{Target: ingresses[i].Labels["nodeName"]}
+	}, func(i int) error {
+		return d.requestIngress(ingresses[i])
+	})
+}
+
+func (d *Detective) dialIngress(pod *core.Pod, ingress *networking.Ingress) error {
+	address, err := d.ingressAddressOf(ingress, pod.Status.HostIP)
+	if err != nil {
+		return d.failIngressAddress(pod, ingress, err)
+	}
+
+	host, path := ingressRoute(ingress)
+	url := fmt.Sprintf("http://%v%v", address, path)
+
+	var args []string
+	if host != "" {
+		args = append(args, "--header", "Host: "+host)
+	}
+
+	command := wgetCommand(url, args...)
+	result := d.retry(func() (string, error) {
+		response, err := d.exec(pod, command)
+		if err == nil {
+			err = checkIngressBackend(ingress, response)
+		}
+		return response, err
+	})
+	result.Scenario = "Ingress"
+	result.Source, result.SourceHostNetwork = pod.Spec.NodeName, pod.Spec.HostNetwork
+	result.Target, result.TargetHostNetwork = ingress.Labels["nodeName"], ingress.Labels["hostNetwork"] == "true"
+
+	if result.Err != nil {
+		klog.V(3).Infof("Error: '%s'", result.Err)
+	}
+
+	reproduce := d.reproduce(&result, pod, command)
+	fmt.Printf("[%v] %30v --> Ingress --> %-30v   %-15v --> %-15v --> %-15v%v\n",
+		result.Status(),
+		pod.Spec.NodeName,
+		ingress.Labels["nodeName"],
+		pod.Status.PodIP,
+		ingressDescription(url, host),
+		ingress.Labels["podIP"],
+		reproduce,
+	)
+
+	d.record(result)
+	return result.Err
+}
+
+// requestIngress probes the ingress from outside of the cluster, i.e. from
+// wherever the detective is running
+func (d *Detective) requestIngress(ingress *networking.Ingress) error {
+	address, err := d.ingressAddressOf(ingress, d.nodeInternalIP(ingress.Labels["nodeName"]))
+	if err != nil {
+		return d.failIngressAddress(nil, ingress, err)
+	}
+
+	host, path := ingressRoute(ingress)
+	url := fmt.Sprintf("http://%v%v", address, path)
+
+	result := d.retry(func() (string, error) {
+		response, err := httpGet(url, host)
+		if err == nil {
+			err = checkIngressBackend(ingress, response)
+		}
+		return response, err
+	})
+	result.Scenario = "Ingress"
+	result.Target, result.TargetHostNetwork = ingress.Labels["nodeName"], ingress.Labels["hostNetwork"] == "true"
+	d.record(result)
+
+	if result.Err != nil {
+		klog.V(3).Infof("Error: '%s'", result.Err)
+	}
+
+	fmt.Printf("[%v] %30v --> Ingress --> %-30v   %-15v --> %-15v --> %-15v\n",
+		result.Status(),
+		"detective",
+		ingress.Labels["nodeName"],
+		"",
+		ingressDescription(url, host),
+		ingress.Labels["podIP"],
+	)
+	return result.Err
+}
+
+// failIngressAddress prints and records the probe of an ingress without an
+// address as failed. The source is nil for probes from the detective.
+func (d *Detective) failIngressAddress(source *core.Pod, ingress *networking.Ingress, err error) error {
+	klog.V(3).Infof("Error: '%s'", err)
+
+	result := checkResult("Ingress", source, ingress.Labels["nodeName"], "", err)
+	result.TargetHostNetwork = ingress.Labels["hostNetwork"] == "true"
+
+	node, ip := "detective", ""
+	if source != nil {
+		node, ip = source.Spec.NodeName, source.Status.PodIP
+	}
+	fmt.Printf("[%v] %30v --> Ingress --> %-30v   %-15v --> %-15v --> %-15v\n",
+		result.Status(),
+		node,
+		ingress.Labels["nodeName"],
+		ip,
+		"no address",
+		ingress.Labels["podIP"],
+	)
+
+	d.record(result)
+	return err
+}
+
+// ingressAddressOf returns the address of the ingress controller. nodeIP is
+// used for IngressNodeAddress.
+func (d *Detective) ingressAddressOf(ingress *networking.Ingress, nodeIP string) (string, error) {
+	switch d.ingressAddress {
+	case "":
+	case IngressNodeAddress:
+		if nodeIP == "" {
+			return "", fmt.Errorf("No node IP known to reach %v", ingress.Name)
+		}
+		return nodeIP, nil
+	default:
+		return d.ingressAddress, nil
+	}
+
+	for _, lb := range ingress.Status.LoadBalancer.Ingress {
+		if lb.IP != "" {
+			return lb.IP, nil
+		}
+		if lb.Hostname != "" {
+			return lb.Hostname, nil
+		}
+	}
+
+	return "", fmt.Errorf("Ingress %v has no address", ingress.Name)
+}
+
+// ingressRoute returns the host header and path to reach the backend of the
+// ingress
+func ingressRoute(ingress *networking.Ingress) (string, string) {
+	rule := ingress.Spec.Rules[0]
+	return rule.Host, rule.HTTP.Paths[0].Path
+}
+
+func ingressDescription(url, host string) string {
+	if host == "" {
+		return url
+	}
+	return fmt.Sprintf("%v (Host: %v)", url, host)
+}
+
+// checkIngressBackend verifies the response was served by the pod behind the
+// ingress. The test image responds with its hostname, which is the node's
+// hostname for pods using hostNetwork.
+func checkIngressBackend(ingress *networking.Ingress, response string) error {
+	hostname := strings.TrimSpace(response)
+	if hostname == ingress.Labels["podName"] {
+		return nil
+	}
+
+	nodeName := ingress.Labels["nodeName"]
+	if ingress.Labels["hostNetwork"] == "true" {
+		if hostname == nodeName || strings.HasPrefix(nodeName, hostname+".") || strings.HasPrefix(hostname, nodeName+".") {
+			return nil
+		}
+	}
+
+	return &ProbeError{Class: ErrorMismatch, Err: fmt.Errorf("Ingress %v responded from %q, expected %v on %v", ingress.Name, hostname, ingress.Labels["podName"], nodeName)}
+}
+
+func httpGet(url, host string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	if host != "" {
+		req.Host = host
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return string(body), &ProbeError{Class: ErrorHTTP, Err: fmt.Errorf("%v returned %v", url, resp.Status)}
+	}
+
+	return string(body), nil
+}
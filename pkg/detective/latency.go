@@ -0,0 +1,196 @@
+package detective
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// PodLatency is the time from creation of a test pod until it was
+// scheduled, running and answered its first inbound probe. The first
+// inbound probe is the readiness probe of the kubelet, which needs the pod
+// network to be wired up.
+type PodLatency struct {
+	Pod       *core.Pod
+	Scheduled time.Duration
+	Running   time.Duration
+	Ready     time.Duration
+}
+
+func podConditionTime(pod *core.Pod, conditionType core.PodConditionType) (time.Time, bool) {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == conditionType && cond.Status == core.ConditionTrue {
+			return cond.LastTransitionTime.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func podRunningTime(pod *core.Pod) (time.Time, bool) {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Running != nil {
+			return status.State.Running.StartedAt.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func newPodLatency(pod *core.Pod) (PodLatency, error) {
+	created := pod.CreationTimestamp.Time
+	latency := PodLatency{Pod: pod}
+
+	scheduled, ok := podConditionTime(pod, core.PodScheduled)
+	if !ok {
+		return latency, fmt.Errorf("Pod %v is not scheduled", pod.Name)
+	}
+
+	running, ok := podRunningTime(pod)
+	if !ok {
+		return latency, fmt.Errorf("Pod %v is not running", pod.Name)
+	}
+
+	ready, ok := podConditionTime(pod, core.PodReady)
+	if !ok {
+		return latency, fmt.Errorf("Pod %v is not ready", pod.Name)
+	}
+
+	latency.Scheduled = scheduled.Sub(created)
+	latency.Running = running.Sub(created)
+	latency.Ready = ready.Sub(created)
+	return latency, nil
+}
+
+// waitForPodsReady gives the running pods up to the pod start timeout to
+// pass their readiness probe
+func (d *Detective) waitForPodsReady() ([]*core.Pod, error) {
+	deadline := time.Now().Add(d.podStartTimeout)
+	for {
+		pods, err := d.informers.Core().V1().Pods().Lister().Pods(d.namespace.Name).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+
+		ready := 0
+		for _, pod := range pods {
+			if _, ok := podConditionTime(pod, core.PodReady); ok {
+				ready++
+			}
+		}
+		klog.V(3).Infof("  %v/%v pods ready", ready, len(pods))
+		if ready == len(pods) || time.Now().After(deadline) {
+			return pods, nil
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-d.tomb.Dying():
+			return nil, fmt.Errorf("Interrupted")
+		}
+	}
+}
+
+// reportPodLatencies prints the setup latencies of the test pods per node.
+// Pods without hostNetwork need the CNI plugin to be ready, comparing them
+// to the hostNetwork pod on the same node shows the time spent in the CNI.
+// Pods which don't become ready are listed without latency, they are still
+// tested. Timestamps of the API have a resolution of one second.
+func (d *Detective) reportPodLatencies() error {
+	klog.V(2).Info("Waiting for ready Pods")
+
+	pods, err := d.waitForPodsReady()
+	if err != nil {
+		return err
+	}
+
+	type nodeLatency struct {
+		node        string
+		pod         *PodLatency
+		hostNetwork *PodLatency
+	}
+
+	nodes := map[string]*nodeLatency{}
+	var scheduled, running, ready, cni []time.Duration
+	var incomplete []string
+	for _, pod := range pods {
+		latency, err := newPodLatency(pod)
+		if err != nil {
+			incomplete = append(incomplete, fmt.Sprintf("%v on %v", pod.Name, pod.Spec.NodeName))
+			klog.V(3).Infof("Error: '%s'", err)
+			continue
+		}
+
+		n, ok := nodes[pod.Spec.NodeName]
+		if !ok {
+			n = &nodeLatency{node: pod.Spec.NodeName}
+			nodes[pod.Spec.NodeName] = n
+		}
+
+		if pod.Spec.HostNetwork {
+			n.hostNetwork = &latency
+		} else {
+			n.pod = &latency
+			scheduled = append(scheduled, latency.Scheduled)
+			running = append(running, latency.Running)
+			ready = append(ready, latency.Ready)
+		}
+	}
+
+	sorted := make([]*nodeLatency, 0, len(nodes))
+	for _, n := range nodes {
+		sorted = append(sorted, n)
+		if n.pod != nil && n.hostNetwork != nil {
+			cni = append(cni, n.pod.Ready-n.hostNetwork.Ready)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].pod == nil || sorted[j].pod == nil {
+			return sorted[i].pod != nil
+		}
+		return sorted[i].pod.Ready > sorted[j].pod.Ready
+	})
+
+	fmt.Println("Pod Latencies (created --> scheduled / running / ready)")
+	fmt.Printf("  %30v   %-26v   %-26v\n", "", "Pod", "Pod (hostNetwork)")
+	for _, n := range sorted {
+		fmt.Printf("  %30v   %-26v   %-26v\n", n.node, describePodLatency(n.pod), describePodLatency(n.hostNetwork))
+	}
+
+	fmt.Printf("  %-10v %v\n", "scheduled", describeDistribution(scheduled))
+	fmt.Printf("  %-10v %v\n", "running", describeDistribution(running))
+	fmt.Printf("  %-10v %v\n", "ready", describeDistribution(ready))
+	fmt.Printf("  %-10v %v\n", "cni", describeDistribution(cni))
+	if len(incomplete) > 0 {
+		sort.Strings(incomplete)
+		fmt.Printf("  %-10v %v\n", "not ready", summarize(incomplete, 5))
+	}
+
+	return nil
+}
+
+func describePodLatency(latency *PodLatency) string {
+	if latency == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%v / %v / %v", latency.Scheduled, latency.Running, latency.Ready)
+}
+
+func describeDistribution(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "-"
+	}
+
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return fmt.Sprintf("min %v, median %v, p90 %v, max %v",
+		sorted[0],
+		sorted[len(sorted)/2],
+		sorted[len(sorted)*9/10],
+		sorted[len(sorted)-1],
+	)
+}
@@ -0,0 +1,138 @@
+package detective
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	LayerUnderlay   = "underlay"
+	LayerOverlay    = "overlay"
+	LayerKubeProxy  = "kube-proxy"
+	LayerExternalIP = "externalIP"
+	LayerUnknown    = "unknown"
+)
+
+// layerDescriptions explain what a layer diagnosis points at
+var layerDescriptions = map[string]string{
+	LayerUnderlay:   "host --> host fails, check the node network",
+	LayerOverlay:    "host --> host works but the pod network fails, check the CNI",
+	LayerKubeProxy:  "pod --> pod works but ClusterIP fails, check kube-proxy",
+	LayerExternalIP: "ClusterIP works but ExternalIP fails, check the external IP routing",
+	LayerUnknown:    "not enough scenarios to tell",
+}
+
+type path struct {
+	scenario          string
+	sourceHostNetwork bool
+	targetHostNetwork bool
+}
+
+// pathResults holds whether the probes of a node pair passed per path.
+// Flaky probes passed.
+type pathResults map[path]bool
+
+// failed returns whether p was tested and failed
+func (r pathResults) failed(p path) bool {
+	passed, tested := r[p]
+	return tested && !passed
+}
+
+// passed returns whether p was tested and passed
+func (r pathResults) passed(p path) bool {
+	return r[p]
+}
+
+// diagnoseLayer infers the layer at fault by comparing the scenarios of a
+// node pair. It returns an empty string if nothing failed.
+func diagnoseLayer(r pathResults) string {
+	hostNetwork := []bool{false, true}
+
+	if r.failed(path{"Pod", true, true}) {
+		return LayerUnderlay
+	}
+
+	for _, s := range hostNetwork {
+		for _, t := range hostNetwork {
+			if r.failed(path{"Pod", s, t}) && r.passed(path{"Pod", true, true}) {
+				return LayerOverlay
+			}
+		}
+	}
+
+	for _, s := range hostNetwork {
+		for _, t := range hostNetwork {
+			if r.failed(path{"ClusterIP", s, t}) && r.passed(path{"Pod", s, t}) {
+				return LayerKubeProxy
+			}
+		}
+	}
+
+	for _, s := range hostNetwork {
+		for _, t := range hostNetwork {
+			if r.failed(path{"ExternalIP", s, t}) && r.passed(path{"ClusterIP", s, t}) {
+				return LayerExternalIP
+			}
+		}
+	}
+
+	for _, passed := range r {
+		if !passed {
+			return LayerUnknown
+		}
+	}
+	return ""
+}
+
+// diagnoseLayers correlates the results of all scenarios per node pair.
+// Exec errors tell nothing about the network and are ignored.
+func diagnoseLayers(results []ProbeResult) map[string][]NodePair {
+	pairs := map[NodePair]pathResults{}
+	for _, r := range results {
+		if r.Source == "" || r.Target == "" || r.Class == ErrorExec && r.Outcome == OutcomeFail {
+			continue
+		}
+
+		pair := NodePair{r.Source, r.Target}
+		if pairs[pair] == nil {
+			pairs[pair] = pathResults{}
+		}
+
+		p := path{r.Scenario, r.SourceHostNetwork, r.TargetHostNetwork}
+		if passed, tested := pairs[pair][p]; !tested || passed {
+			pairs[pair][p] = r.Outcome != OutcomeFail
+		}
+	}
+
+	layers := map[string][]NodePair{}
+	for pair, r := range pairs {
+		if layer := diagnoseLayer(r); layer != "" {
+			layers[layer] = append(layers[layer], pair)
+		}
+	}
+	return layers
+}
+
+func (d *Detective) printLayerDiagnosis() {
+	layers := diagnoseLayers(d.results)
+	if len(layers) == 0 {
+		return
+	}
+
+	fmt.Println("Layer Diagnosis")
+	for _, layer := range []string{LayerUnderlay, LayerOverlay, LayerKubeProxy, LayerExternalIP, LayerUnknown} {
+		pairs := layers[layer]
+		if len(pairs) == 0 {
+			continue
+		}
+
+		var names []string
+		for _, pair := range pairs {
+			names = append(names, fmt.Sprintf("%v --> %v", pair.Source, pair.Target))
+		}
+		sort.Strings(names)
+
+		fmt.Printf("  %-12v %v pairs: %v\n", layer, len(pairs), layerDescriptions[layer])
+		fmt.Printf("  %-12v %v\n", "", summarize(names, 5))
+	}
+}
@@ -0,0 +1,67 @@
+package detective
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// nodeCollectors run in the privileged hostNetwork pod of a node, which
+// shares the network namespace of the node. Tools missing from the test image
+// show up as errors in the output.
+var nodeCollectors = []struct {
+	name   string
+	script string
+}{
+	{"routes", "ip route show table all; ip -6 route show table all; ip rule show"},
+	{"links", "ip -d link show"},
+	{"addresses", "ip addr show"},
+	{"iptables", "iptables-save -c; ip6tables-save -c"},
+	{"ipvs", "ipvsadm -Ln --stats || cat /proc/net/ip_vs"},
+	{"conntrack", "echo count $(cat /proc/sys/net/netfilter/nf_conntrack_count); echo max $(cat /proc/sys/net/netfilter/nf_conntrack_max)"},
+	{"sysctls", "for f in /proc/sys/net/ipv4/ip_forward /proc/sys/net/ipv4/conf/*/rp_filter /proc/sys/net/bridge/bridge-nf-call-iptables; do echo $f $(cat $f); done"},
+}
+
+// collectNodeDiagnostics runs the collectors on the given nodes and adds
+// their output to the bundle
+func (d *Detective) collectNodeDiagnostics(b *bundle, nodes []string) error {
+	pods, err := d.listPods(true)
+	if err != nil {
+		return err
+	}
+
+	podOnNode := map[string]*core.Pod{}
+	for _, pod := range pods {
+		podOnNode[pod.Spec.NodeName] = pod
+	}
+
+	var result *multierror.Error
+	var mutex sync.Mutex
+
+	workqueue.ParallelizeUntil(d.tomb.Context(nil), d.workerCount, len(nodes), func(i int) {
+		pod, ok := podOnNode[nodes[i]]
+		if !ok {
+			mutex.Lock()
+			result = multierror.Append(result, fmt.Errorf("No hostNetwork pod on %v to collect diagnostics", nodes[i]))
+			mutex.Unlock()
+			return
+		}
+
+		for _, c := range nodeCollectors {
+			out, err := d.exec(pod, []string{"sh", "-c", c.script})
+			if err != nil {
+				out = fmt.Sprintf("%v\n%v\n", out, err)
+			}
+
+			mutex.Lock()
+			result = multierror.Append(result, b.add(filepath.Join("nodes", nodes[i], c.name+".txt"), []byte(out)))
+			mutex.Unlock()
+		}
+	})
+
+	return result.ErrorOrNil()
+}
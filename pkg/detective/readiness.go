@@ -0,0 +1,195 @@
+package detective
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+type readinessState struct {
+	have   int
+	reason string
+	err    error
+}
+
+// readiness tracks the readiness of the objects we wait for. It is fed by
+// informer event handlers, so waiting doesn't require listing all objects
+// and progress can name the objects still missing.
+type readiness struct {
+	kind     string
+	mutex    sync.Mutex
+	state    map[string]readinessState
+	expected map[string]int
+	notify   chan struct{}
+}
+
+func newReadiness(kind string) *readiness {
+	return &readiness{
+		kind:     kind,
+		state:    map[string]readinessState{},
+		expected: map[string]int{},
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// expect registers an object which needs want ready instances, e.g. the
+// number of ready addresses of an endpoints object
+func (r *readiness) expect(name string, want int) {
+	r.mutex.Lock()
+	r.expected[name] = want
+	r.mutex.Unlock()
+	r.changed()
+}
+
+// update records the current state of an object. err marks the object as
+// failed permanently.
+func (r *readiness) update(name string, have int, reason string, err error) {
+	r.mutex.Lock()
+	r.state[name] = readinessState{have, reason, err}
+	r.mutex.Unlock()
+	r.changed()
+}
+
+func (r *readiness) remove(name string) {
+	r.mutex.Lock()
+	delete(r.state, name)
+	r.mutex.Unlock()
+	r.changed()
+}
+
+func (r *readiness) changed() {
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// missing returns a description of every expected object which is not
+// ready, the number of ready objects and the first error encountered
+func (r *readiness) missing() ([]string, int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var missing []string
+	for name, want := range r.expected {
+		state, ok := r.state[name]
+		if state.err != nil {
+			return nil, 0, state.err
+		}
+
+		switch {
+		case !ok:
+			missing = append(missing, fmt.Sprintf("%v (not found)", name))
+		case state.have < want:
+			missing = append(missing, fmt.Sprintf("%v (%v/%v %v)", name, state.have, want, state.reason))
+		}
+	}
+
+	sort.Strings(missing)
+	return missing, len(r.expected) - len(missing), nil
+}
+
+// wait blocks until all expected objects are ready. It returns
+// wait.ErrWaitTimeout if timeout passes first.
+func (r *readiness) wait(stop <-chan struct{}, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	progress := time.NewTicker(WaitForEndpointInterval)
+	defer progress.Stop()
+
+	for {
+		missing, ready, err := r.missing()
+		if err != nil {
+			return err
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+
+		select {
+		case <-r.notify:
+		case <-progress.C:
+			klog.V(3).Infof("  %v/%v %v ready, waiting for %v", ready, ready+len(missing), r.kind, summarize(missing, 5))
+		case <-deadline:
+			return wait.ErrWaitTimeout
+		case <-stop:
+			return fmt.Errorf("Interrupted")
+		}
+	}
+}
+
+func summarize(items []string, max int) string {
+	if len(items) <= max {
+		return strings.Join(items, ", ")
+	}
+	return fmt.Sprintf("%v and %v more", strings.Join(items[:max], ", "), len(items)-max)
+}
+
+// podReadiness returns whether the pod is running. Readiness is not
+// required, a pod failing its readiness probe still gets tested.
+func podReadiness(pod *core.Pod) (int, string, error) {
+	switch pod.Status.Phase {
+	case core.PodRunning:
+		return 1, "", nil
+	case core.PodFailed:
+		return 0, "", fmt.Errorf("Failed to create Pod %v on %v: %v %v", pod.Name, pod.Spec.NodeName, pod.Status.Reason, pod.Status.Message)
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil && status.State.Waiting.Reason != "" {
+			return 0, status.State.Waiting.Reason, nil
+		}
+	}
+	return 0, string(pod.Status.Phase), nil
+}
+
+func endpointsReadiness(endpoints *core.Endpoints) (int, string, error) {
+	ready, notReady := 0, 0
+	for _, subset := range endpoints.Subsets {
+		ready += len(subset.Addresses)
+		notReady += len(subset.NotReadyAddresses)
+	}
+	return ready, fmt.Sprintf("addresses ready, %v not ready", notReady), nil
+}
+
+// readinessHandler feeds readiness with the state of the objects of an
+// informer
+func readinessHandler(r *readiness, name func(obj interface{}) string, state func(obj interface{}) (int, string, error)) cache.ResourceEventHandlerFuncs {
+	update := func(obj interface{}) {
+		have, reason, err := state(obj)
+		r.update(name(obj), have, reason, err)
+	}
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: update,
+		UpdateFunc: func(_, obj interface{}) {
+			update(obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			r.remove(name(obj))
+		},
+	}
+}
+
+func (d *Detective) registerPodReadiness(pods cache.SharedIndexInformer) {
+	pods.AddEventHandler(readinessHandler(d.podsReady,
+		func(obj interface{}) string { return obj.(*core.Pod).Name },
+		func(obj interface{}) (int, string, error) { return podReadiness(obj.(*core.Pod)) },
+	))
+}
+
+func (d *Detective) registerEndpointsReadiness(endpoints cache.SharedIndexInformer) {
+	endpoints.AddEventHandler(readinessHandler(d.endpointsReady,
+		func(obj interface{}) string { return obj.(*core.Endpoints).Name },
+		func(obj interface{}) (int, string, error) { return endpointsReadiness(obj.(*core.Endpoints)) },
+	))
+}
@@ -0,0 +1,52 @@
+package detective
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Report is the machine readable summary of a run written by -report
+type Report struct {
+	Results    []ProbeResult         `json:"results"`
+	Suspects   []NodeScore           `json:"suspects,omitempty"`
+	Layers     map[string][]NodePair `json:"layers,omitempty"`
+	Asymmetric []NodePair            `json:"asymmetric,omitempty"`
+	Components [][]string            `json:"components,omitempty"`
+}
+
+// MarshalJSON adds the error message, which error doesn't marshal by itself
+func (r ProbeResult) MarshalJSON() ([]byte, error) {
+	type result ProbeResult
+	var message string
+	if r.Err != nil {
+		message = r.Err.Error()
+	}
+
+	return json.Marshal(struct {
+		result
+		Error string `json:"error,omitempty"`
+	}{result(r), message})
+}
+
+func (d *Detective) newReport() Report {
+	reachable := reachability(d.results)
+	return Report{
+		Results:    d.results,
+		Suspects:   rankSuspectNodes(d.results),
+		Layers:     diagnoseLayers(d.results),
+		Asymmetric: asymmetricPairs(reachable),
+		Components: connectedComponents(reachable),
+	}
+}
+
+func (d *Detective) writeReport(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(d.newReport())
+}
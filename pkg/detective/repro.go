@@ -0,0 +1,63 @@
+package detective
+
+import (
+	"fmt"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+)
+
+// kubectlExec returns the kubectl command equivalent to the exec of command
+// in the test pod
+func (d *Detective) kubectlExec(pod *core.Pod, command []string) string {
+	quoted := make([]string, 0, len(command))
+	for _, arg := range command {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return fmt.Sprintf("kubectl exec -n %v %v -c server -- %v", d.namespace.Name, pod.Name, strings.Join(quoted, " "))
+}
+
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_=.,:/@%+", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// reproduce records the command to reproduce a failed probe manually and
+// returns it as a line to append to the result line. Both are printed at
+// once, so lines of concurrent probes don't end up in between.
+func (d *Detective) reproduce(result *ProbeResult, pod *core.Pod, command []string) string {
+	if result.Outcome == OutcomePass || result.Outcome == OutcomeFlaky {
+		return ""
+	}
+	result.Reproduce = d.kubectlExec(pod, command)
+	return reproduceLine(result.Reproduce)
+}
+
+func reproduceLine(command string) string {
+	return fmt.Sprintf("\n  reproduce: %v", command)
+}
+
+// printKeepHint tells how to reproduce the failures against the test bed. It
+// is only printed along with reproduce commands.
+func (d *Detective) printKeepHint() {
+	reproducible := false
+	for _, r := range d.results {
+		if r.Reproduce != "" {
+			reproducible = true
+			break
+		}
+	}
+	if !reproducible {
+		return
+	}
+
+	if d.keep {
+		fmt.Printf("Keeping the test bed in namespace %v to reproduce failures, delete it with: kubectl delete namespace %v\n", d.namespace.Name, d.namespace.Name)
+		return
+	}
+	fmt.Printf("The test bed in namespace %v is deleted, rerun with -keep to reproduce failures with the commands above\n", d.namespace.Name)
+}
@@ -0,0 +1,183 @@
+package detective
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	OutcomePass     = "success"
+	OutcomeFlaky    = "flaky"
+	OutcomeFail     = "failure"
+	OutcomeSecurity = "security"
+)
+
+// ProbeResult is the outcome of a single probe from a source to a target
+// node. Source is empty for probes from the detective itself, Target for
+// probes of targets outside of the cluster or of services backed by all
+// nodes.
+type ProbeResult struct {
+	Scenario          string `json:"scenario"`
+	Source            string `json:"source,omitempty"`
+	Target            string `json:"target,omitempty"`
+	SourceHostNetwork bool   `json:"sourceHostNetwork"`
+	TargetHostNetwork bool   `json:"targetHostNetwork"`
+	Outcome           string `json:"outcome"`
+	Class             string `json:"class,omitempty"`
+	Attempts          int    `json:"attempts"`
+	Output            string `json:"output,omitempty"`
+	Err               error  `json:"-"`
+
+	// Duration of the last attempt. It is measured around the exec, so it
+	// includes the round trip through the API server and the kubelet.
+	Duration time.Duration `json:"duration"`
+	// Captures are the pcap files of the re-run failed probe
+	Captures []string `json:"captures,omitempty"`
+	// CaptureOutcome is the outcome of the re-run. If it passed, the
+	// captures likely don't show the failure.
+	CaptureOutcome string `json:"captureOutcome,omitempty"`
+	// Reproduce is the kubectl command to re-run a failed probe manually
+	Reproduce string `json:"reproduce,omitempty"`
+}
+
+func (r ProbeResult) String() string {
+	return fmt.Sprintf("%v: %v --> %v", r.Scenario, describeNode(r.Source, r.SourceHostNetwork), describeNode(r.Target, r.TargetHostNetwork))
+}
+
+// Status is the outcome along with the error class of failures
+func (r ProbeResult) Status() string {
+	if r.Outcome == OutcomeFail && r.Class != "" {
+		return r.Outcome + " " + r.Class
+	}
+	return r.Outcome
+}
+
+func describeNode(name string, hostNetwork bool) string {
+	switch {
+	case name == "":
+		return "-"
+	case hostNetwork:
+		return name + " (hostNetwork)"
+	}
+	return name
+}
+
+// checkResult returns the result of a check which isn't a single retried
+// probe, e.g. a series of requests or a comparison of API objects. Errors
+// which aren't classified by their cause are of class.
+func checkResult(scenario string, source *core.Pod, target string, class string, err error) ProbeResult {
+	r := ProbeResult{Scenario: scenario, Target: target, Attempts: 1, Outcome: OutcomePass, Err: err}
+	if source != nil {
+		r.Source, r.SourceHostNetwork = source.Spec.NodeName, source.Spec.HostNetwork
+	}
+	if err != nil {
+		r.Outcome = OutcomeFail
+		r.Output = err.Error()
+		r.Class = errorClass(err)
+		if r.Class == ErrorOther && class != "" {
+			r.Class = class
+		}
+	}
+	return r
+}
+
+// retry runs probe up to 1+retries times and doubles the backoff after each
+// failed attempt. A probe which passes after a retry is flaky. The class is
+// the one of the last failed attempt.
+func (d *Detective) retry(probe func() (string, error)) ProbeResult {
+	r := ProbeResult{}
+	backoff := d.retryBackoff
+
+	for {
+		r.Attempts++
+		start := time.Now()
+		r.Output, r.Err = probe()
+		r.Duration = time.Since(start)
+		if r.Err != nil {
+			r.Class = errorClass(r.Err)
+		}
+		if r.Err == nil || r.Attempts > d.retries || !d.tomb.Alive() {
+			break
+		}
+
+		klog.V(3).Infof("Attempt %v failed, retrying in %v: '%s'", r.Attempts, backoff, r.Err)
+		select {
+		case <-time.After(backoff):
+		case <-d.tomb.Dying():
+		}
+		backoff *= 2
+	}
+
+	switch {
+	case r.Err != nil:
+		r.Outcome = OutcomeFail
+	case r.Attempts > 1:
+		r.Outcome = OutcomeFlaky
+	default:
+		r.Outcome = OutcomePass
+	}
+	return r
+}
+
+// record keeps the result of a probe for the summary. The output is only
+// kept for probes which didn't pass right away, as it adds up over all
+// pairs of nodes. It returns the index of the result.
+func (d *Detective) record(r ProbeResult) int {
+	if r.Outcome == OutcomePass {
+		r.Output = ""
+	}
+
+	d.resultsMutex.Lock()
+	defer d.resultsMutex.Unlock()
+
+	d.results = append(d.results, r)
+	return len(d.results) - 1
+}
+
+func (d *Detective) printFlaky() {
+	var flaky []ProbeResult
+	for _, r := range d.results {
+		if r.Outcome == OutcomeFlaky {
+			flaky = append(flaky, r)
+		}
+	}
+
+	if len(flaky) == 0 {
+		return
+	}
+
+	fmt.Printf("Flaky (%v)\n", len(flaky))
+	for _, r := range flaky {
+		fmt.Printf("  %v after %v attempts (%v)\n", r, r.Attempts, r.Class)
+	}
+}
+
+// printFailureClasses counts the failed probes by error class. Exec errors
+// point at the kubelets or the API server rather than the network.
+func (d *Detective) printFailureClasses() {
+	classes := map[string]int{}
+	for _, r := range d.results {
+		if r.Outcome == OutcomeFail {
+			classes[r.Class]++
+		}
+	}
+
+	if len(classes) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(classes))
+	for class := range classes {
+		names = append(names, class)
+	}
+	sort.Strings(names)
+
+	fmt.Println("Failures by class")
+	for _, class := range names {
+		fmt.Printf("  %-12v %v\n", class, classes[class])
+	}
+}
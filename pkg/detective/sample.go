@@ -0,0 +1,200 @@
+package detective
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+)
+
+const (
+	SampleAll    = "all"
+	SampleRandom = "random"
+	SamplePairs  = "pairs"
+	SampleZones  = "zones"
+	SampleCover  = "cover"
+)
+
+// NodePair is a directed pair of nodes, e.g. the nodes of the source and
+// the target pod of a probe
+type NodePair struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// Sampling selects the node pairs which are tested. The syntax is
+// `strategy[:k]`.
+//
+//	all       every permutation of nodes
+//	random:k  k random targets per source node
+//	pairs     one random direction per unordered node pair
+//	zones:k   all pairs between k representatives per zone
+//	cover:k   every node is source and target exactly k times
+//
+// Except for all, the self pair of every node is added to the sampled pairs,
+// so paths within a node, e.g. to its hostNetwork pod, are always tested.
+type Sampling struct {
+	Strategy string
+	K        int
+	Seed     int64
+}
+
+func ParseSampling(s string) (Sampling, error) {
+	sampling := Sampling{Strategy: s, K: 1}
+
+	if i := strings.Index(s, ":"); i >= 0 {
+		k, err := strconv.Atoi(s[i+1:])
+		if err != nil || k < 1 {
+			return sampling, fmt.Errorf("Invalid k in %q", s)
+		}
+		sampling.Strategy = s[:i]
+		sampling.K = k
+	}
+
+	switch sampling.Strategy {
+	case SampleAll, SampleRandom, SamplePairs, SampleZones, SampleCover:
+	default:
+		return sampling, fmt.Errorf("Unknown sampling strategy %q", sampling.Strategy)
+	}
+
+	return sampling, nil
+}
+
+func (o *Options) sampling() Sampling {
+	if o.Sample == "" {
+		return Sampling{Strategy: SampleAll}
+	}
+
+	sampling, err := ParseSampling(o.Sample)
+	if err != nil {
+		fmt.Printf("Couldn't parse -sample: %v\n", err)
+		os.Exit(1)
+	}
+	sampling.Seed = o.SampleSeed
+
+	return sampling
+}
+
+// Pairs returns the sampled node pairs. nil means all pairs are tested.
+func (s Sampling) Pairs(nodes []*core.Node) map[NodePair]bool {
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+	sort.Strings(names)
+
+	random := rand.New(rand.NewSource(s.Seed))
+	pairs := map[NodePair]bool{}
+
+	switch s.Strategy {
+	case SampleRandom:
+		for _, source := range names {
+			targets := random.Perm(len(names))
+			picked := 0
+			for _, t := range targets {
+				if picked == s.K {
+					break
+				}
+				if names[t] == source {
+					continue
+				}
+				pairs[NodePair{source, names[t]}] = true
+				picked++
+			}
+		}
+
+	case SamplePairs:
+		for i := range names {
+			for j := i + 1; j < len(names); j++ {
+				if random.Intn(2) == 0 {
+					pairs[NodePair{names[i], names[j]}] = true
+				} else {
+					pairs[NodePair{names[j], names[i]}] = true
+				}
+			}
+		}
+
+	case SampleZones:
+		zones := nodesByZone(nodes)
+		zoneNames := make([]string, 0, len(zones))
+		for name := range zones {
+			zoneNames = append(zoneNames, name)
+		}
+		sort.Strings(zoneNames)
+
+		var representatives []string
+		for _, name := range zoneNames {
+			zone := zones[name]
+			random.Shuffle(len(zone), func(i, j int) { zone[i], zone[j] = zone[j], zone[i] })
+			if len(zone) > s.K {
+				zone = zone[:s.K]
+			}
+			representatives = append(representatives, zone...)
+		}
+		for _, source := range representatives {
+			for _, target := range representatives {
+				if source != target {
+					pairs[NodePair{source, target}] = true
+				}
+			}
+		}
+
+	case SampleCover:
+		// cyclic shifts of a random order make every node source and target
+		// of exactly k pairs
+		random.Shuffle(len(names), func(i, j int) { names[i], names[j] = names[j], names[i] })
+		for offset := 1; offset <= s.K && offset < len(names); offset++ {
+			for i, source := range names {
+				pairs[NodePair{source, names[(i+offset)%len(names)]}] = true
+			}
+		}
+
+	default:
+		return nil
+	}
+
+	for _, name := range names {
+		pairs[NodePair{name, name}] = true
+	}
+
+	return pairs
+}
+
+func nodesByZone(nodes []*core.Node) map[string][]string {
+	zones := map[string][]string{}
+	for _, node := range nodes {
+		zone, ok := node.Labels[core.LabelTopologyZone]
+		if !ok {
+			zone = node.Labels[core.LabelFailureDomainBetaZone]
+		}
+		zones[zone] = append(zones[zone], node.Name)
+	}
+
+	for _, names := range zones {
+		sort.Strings(names)
+	}
+	return zones
+}
+
+func (d *Detective) sampleNodePairs() error {
+	nodes, err := d.ListNodesWithPredicate(d.NodeIsSchedulabeleAndRunning)
+	if err != nil {
+		return err
+	}
+
+	d.samples = d.sampling.Pairs(nodes)
+	if d.samples != nil {
+		fmt.Printf("Sampling %v of %v node pairs (%v:%v, seed %v)\n", len(d.samples), len(nodes)*len(nodes), d.sampling.Strategy, d.sampling.K, d.sampling.Seed)
+	}
+
+	return nil
+}
+
+// sampled returns whether probes from source to target node are tested
+func (d *Detective) sampled(source, target string) bool {
+	return d.samples == nil || d.samples[NodePair{source, target}]
+}
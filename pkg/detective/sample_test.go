@@ -0,0 +1,216 @@
+package detective
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testNodes returns count nodes spread round-robin over zones
+func testNodes(count, zones int) []*core.Node {
+	nodes := make([]*core.Node, 0, count)
+	for i := 0; i < count; i++ {
+		nodes = append(nodes, &core.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: fmt.Sprintf("node-%02d", i),
+				Labels: map[string]string{
+					core.LabelTopologyZone: fmt.Sprintf("zone-%v", i%zones),
+				},
+			},
+		})
+	}
+	return nodes
+}
+
+// count returns how often each node is source and target of pairs, self
+// pairs excluded
+func count(pairs map[NodePair]bool) (sources, targets map[string]int) {
+	sources, targets = map[string]int{}, map[string]int{}
+	for pair := range pairs {
+		if pair.Source == pair.Target {
+			continue
+		}
+		sources[pair.Source]++
+		targets[pair.Target]++
+	}
+	return sources, targets
+}
+
+func TestParseSampling(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Sampling
+		err      bool
+	}{
+		{input: "all", expected: Sampling{Strategy: SampleAll, K: 1}},
+		{input: "random", expected: Sampling{Strategy: SampleRandom, K: 1}},
+		{input: "random:3", expected: Sampling{Strategy: SampleRandom, K: 3}},
+		{input: "pairs", expected: Sampling{Strategy: SamplePairs, K: 1}},
+		{input: "zones:2", expected: Sampling{Strategy: SampleZones, K: 2}},
+		{input: "cover:5", expected: Sampling{Strategy: SampleCover, K: 5}},
+		{input: "", err: true},
+		{input: "bogus", err: true},
+		{input: "bogus:2", err: true},
+		{input: "random:", err: true},
+		{input: "random:0", err: true},
+		{input: "random:-1", err: true},
+		{input: "random:x", err: true},
+	}
+
+	for _, test := range tests {
+		sampling, err := ParseSampling(test.input)
+		if test.err {
+			if err == nil {
+				t.Errorf("ParseSampling(%q) = %+v, expected an error", test.input, sampling)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSampling(%q) failed: %v", test.input, err)
+			continue
+		}
+		if sampling != test.expected {
+			t.Errorf("ParseSampling(%q) = %+v, expected %+v", test.input, sampling, test.expected)
+		}
+	}
+}
+
+func TestSamplingPairs(t *testing.T) {
+	tests := []struct {
+		name     string
+		sampling Sampling
+		nodes    int
+		zones    int
+		check    func(t *testing.T, nodes []*core.Node, pairs map[NodePair]bool)
+	}{
+		{
+			name:     "all",
+			sampling: Sampling{Strategy: SampleAll},
+			nodes:    5,
+			zones:    1,
+			check: func(t *testing.T, nodes []*core.Node, pairs map[NodePair]bool) {
+				if pairs != nil {
+					t.Errorf("expected nil for all pairs, got %v", pairs)
+				}
+			},
+		},
+		{
+			name:     "random targets per source",
+			sampling: Sampling{Strategy: SampleRandom, K: 3},
+			nodes:    10,
+			zones:    1,
+			check: func(t *testing.T, nodes []*core.Node, pairs map[NodePair]bool) {
+				sources, _ := count(pairs)
+				for _, node := range nodes {
+					if sources[node.Name] != 3 {
+						t.Errorf("%v is source of %v pairs, expected 3", node.Name, sources[node.Name])
+					}
+				}
+			},
+		},
+		{
+			name:     "random with k above the node count",
+			sampling: Sampling{Strategy: SampleRandom, K: 10},
+			nodes:    4,
+			zones:    1,
+			check: func(t *testing.T, nodes []*core.Node, pairs map[NodePair]bool) {
+				if len(pairs) != 4*4 {
+					t.Errorf("got %v pairs, expected all %v", len(pairs), 4*4)
+				}
+			},
+		},
+		{
+			name:     "pairs in one direction",
+			sampling: Sampling{Strategy: SamplePairs},
+			nodes:    7,
+			zones:    1,
+			check: func(t *testing.T, nodes []*core.Node, pairs map[NodePair]bool) {
+				for i, source := range nodes {
+					for _, target := range nodes[i+1:] {
+						forward := pairs[NodePair{source.Name, target.Name}]
+						backward := pairs[NodePair{target.Name, source.Name}]
+						if forward == backward {
+							t.Errorf("%v and %v: forward %v, backward %v, expected exactly one", source.Name, target.Name, forward, backward)
+						}
+					}
+				}
+			},
+		},
+		{
+			name:     "zones representatives",
+			sampling: Sampling{Strategy: SampleZones, K: 2},
+			nodes:    12,
+			zones:    3,
+			check: func(t *testing.T, nodes []*core.Node, pairs map[NodePair]bool) {
+				sources, targets := count(pairs)
+				if len(sources) != 6 {
+					t.Errorf("got %v representatives, expected 2 for each of 3 zones", len(sources))
+				}
+				zones := map[string]int{}
+				for _, node := range nodes {
+					if sources[node.Name] > 0 {
+						zones[node.Labels[core.LabelTopologyZone]]++
+					}
+				}
+				for zone, n := range zones {
+					if n != 2 {
+						t.Errorf("%v has %v representatives, expected 2", zone, n)
+					}
+				}
+				for source := range sources {
+					if sources[source] != 5 || targets[source] != 5 {
+						t.Errorf("%v is source of %v and target of %v pairs, expected 5 each", source, sources[source], targets[source])
+					}
+				}
+			},
+		},
+		{
+			name:     "cover",
+			sampling: Sampling{Strategy: SampleCover, K: 3},
+			nodes:    10,
+			zones:    1,
+			check: func(t *testing.T, nodes []*core.Node, pairs map[NodePair]bool) {
+				sources, targets := count(pairs)
+				for _, node := range nodes {
+					if sources[node.Name] != 3 || targets[node.Name] != 3 {
+						t.Errorf("%v is source of %v and target of %v pairs, expected 3 each", node.Name, sources[node.Name], targets[node.Name])
+					}
+				}
+			},
+		},
+		{
+			name:     "cover with k above the node count",
+			sampling: Sampling{Strategy: SampleCover, K: 10},
+			nodes:    3,
+			zones:    1,
+			check: func(t *testing.T, nodes []*core.Node, pairs map[NodePair]bool) {
+				if len(pairs) != 3*3 {
+					t.Errorf("got %v pairs, expected all %v", len(pairs), 3*3)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nodes := testNodes(test.nodes, test.zones)
+			pairs := test.sampling.Pairs(nodes)
+			test.check(t, nodes, pairs)
+
+			if pairs == nil {
+				return
+			}
+			for _, node := range nodes {
+				if !pairs[NodePair{node.Name, node.Name}] {
+					t.Errorf("self pair of %v is missing", node.Name)
+				}
+			}
+			if again := test.sampling.Pairs(nodes); !reflect.DeepEqual(pairs, again) {
+				t.Errorf("pairs differ for the same seed")
+			}
+		})
+	}
+}
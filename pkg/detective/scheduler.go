@@ -0,0 +1,118 @@
+package detective
+
+import (
+	"context"
+	"sync"
+)
+
+// scheduler hands out probes to the workers. It limits the probes in flight
+// per source and per target node, so concurrent execs don't pile up on the
+// same kubelet, and interleaves the sources to spread the load evenly. An
+// empty node name, e.g. for external targets, is not limited.
+type scheduler struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+
+	pairs   func(i int) NodePair
+	sources []string
+	queues  map[string][]int
+	cursor  int
+
+	inflightSource map[string]int
+	inflightTarget map[string]int
+	maxPerSource   int
+	maxPerTarget   int
+}
+
+func newScheduler(n int, pairs func(i int) NodePair, maxPerSource, maxPerTarget int) *scheduler {
+	s := &scheduler{
+		pairs:          pairs,
+		queues:         map[string][]int{},
+		inflightSource: map[string]int{},
+		inflightTarget: map[string]int{},
+		maxPerSource:   maxPerSource,
+		maxPerTarget:   maxPerTarget,
+	}
+	s.cond = sync.NewCond(&s.mutex)
+
+	for i := 0; i < n; i++ {
+		source := pairs(i).Source
+		if _, ok := s.queues[source]; !ok {
+			s.sources = append(s.sources, source)
+		}
+		s.queues[source] = append(s.queues[source], i)
+	}
+
+	// rotate the targets of each source, so the sources don't start with
+	// the same target
+	for k, source := range s.sources {
+		queue := s.queues[source]
+		shift := k % len(queue)
+		s.queues[source] = append(queue[shift:], queue[:shift]...)
+	}
+
+	return s
+}
+
+func (s *scheduler) runnable(pair NodePair) bool {
+	if pair.Source != "" && s.maxPerSource > 0 && s.inflightSource[pair.Source] >= s.maxPerSource {
+		return false
+	}
+	if pair.Target != "" && s.maxPerTarget > 0 && s.inflightTarget[pair.Target] >= s.maxPerTarget {
+		return false
+	}
+	return true
+}
+
+// next blocks until a probe can run without exceeding the limits and
+// returns its index
+func (s *scheduler) next(ctx context.Context) (int, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for ctx.Err() == nil {
+		for k := 0; k < len(s.sources); k++ {
+			source := s.sources[(s.cursor+k)%len(s.sources)]
+			queue := s.queues[source]
+			if len(queue) == 0 || !s.runnable(NodePair{Source: source}) {
+				continue
+			}
+
+			for j, i := range queue {
+				pair := s.pairs(i)
+				if !s.runnable(pair) {
+					continue
+				}
+
+				s.queues[source] = append(queue[:j], queue[j+1:]...)
+				s.inflightSource[pair.Source]++
+				s.inflightTarget[pair.Target]++
+				s.cursor = (s.cursor + k + 1) % len(s.sources)
+				return i, true
+			}
+		}
+		s.cond.Wait()
+	}
+
+	return 0, false
+}
+
+// done releases the limits held by probe i
+func (s *scheduler) done(i int) {
+	pair := s.pairs(i)
+
+	s.mutex.Lock()
+	s.inflightSource[pair.Source]--
+	s.inflightTarget[pair.Target]--
+	s.mutex.Unlock()
+
+	s.cond.Broadcast()
+}
+
+// wakeup releases workers waiting in next once ctx is done
+func (s *scheduler) wakeup(ctx context.Context) {
+	<-ctx.Done()
+	s.mutex.Lock()
+	s.mutex.Unlock()
+	s.cond.Broadcast()
+}
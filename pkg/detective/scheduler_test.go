@@ -0,0 +1,195 @@
+package detective
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// inflight tracks the probes running per node and the maximum seen
+type inflight struct {
+	mutex     sync.Mutex
+	source    map[string]int
+	target    map[string]int
+	maxSource int
+	maxTarget int
+	ran       map[int]int
+}
+
+func newInflight() *inflight {
+	return &inflight{source: map[string]int{}, target: map[string]int{}, ran: map[int]int{}}
+}
+
+func (f *inflight) start(i int, pair NodePair) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.ran[i]++
+	f.source[pair.Source]++
+	f.target[pair.Target]++
+	if f.source[pair.Source] > f.maxSource {
+		f.maxSource = f.source[pair.Source]
+	}
+	if f.target[pair.Target] > f.maxTarget {
+		f.maxTarget = f.target[pair.Target]
+	}
+}
+
+func (f *inflight) stop(pair NodePair) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.source[pair.Source]--
+	f.target[pair.Target]--
+}
+
+// runScheduled runs the pairs like runProbes and fails the test if they
+// don't finish within a timeout
+func runScheduled(t *testing.T, ctx context.Context, workers int, pairs []NodePair, maxPerSource, maxPerTarget int) *inflight {
+	t.Helper()
+
+	pair := func(i int) NodePair { return pairs[i] }
+	s := newScheduler(len(pairs), pair, maxPerSource, maxPerTarget)
+	f := newInflight()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go s.wakeup(ctx)
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		workqueue.ParallelizeUntil(ctx, workers, len(pairs), func(_ int) {
+			i, ok := s.next(ctx)
+			if !ok {
+				return
+			}
+			f.start(i, pair(i))
+			time.Sleep(time.Millisecond)
+			f.stop(pair(i))
+			s.done(i)
+		})
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("%v pairs didn't finish", len(pairs))
+	}
+	return f
+}
+
+func allPairs(nodes int) []NodePair {
+	var pairs []NodePair
+	for i := 0; i < nodes; i++ {
+		for j := 0; j < nodes; j++ {
+			pairs = append(pairs, NodePair{fmt.Sprintf("node-%v", i), fmt.Sprintf("node-%v", j)})
+		}
+	}
+	return pairs
+}
+
+func TestSchedulerLimits(t *testing.T) {
+	tests := []struct {
+		name         string
+		pairs        []NodePair
+		workers      int
+		maxPerSource int
+		maxPerTarget int
+	}{
+		{name: "all pairs", pairs: allPairs(8), workers: 32, maxPerSource: 2, maxPerTarget: 2},
+		{name: "one per node", pairs: allPairs(8), workers: 32, maxPerSource: 1, maxPerTarget: 1},
+		{name: "more workers than pairs", pairs: allPairs(3), workers: 50, maxPerSource: 2, maxPerTarget: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := runScheduled(t, context.Background(), test.workers, test.pairs, test.maxPerSource, test.maxPerTarget)
+
+			if f.maxSource > test.maxPerSource {
+				t.Errorf("%v probes in flight per source, limit %v", f.maxSource, test.maxPerSource)
+			}
+			if f.maxTarget > test.maxPerTarget {
+				t.Errorf("%v probes in flight per target, limit %v", f.maxTarget, test.maxPerTarget)
+			}
+			for i := range test.pairs {
+				if f.ran[i] != 1 {
+					t.Errorf("probe %v ran %v times, expected once", i, f.ran[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSchedulerSharedNode(t *testing.T) {
+	tests := []struct {
+		name  string
+		pairs func(i int) NodePair
+	}{
+		{name: "same source", pairs: func(i int) NodePair { return NodePair{"node-0", fmt.Sprintf("node-%v", i)} }},
+		{name: "same target", pairs: func(i int) NodePair { return NodePair{fmt.Sprintf("node-%v", i), "node-0"} }},
+		{name: "same pair", pairs: func(i int) NodePair { return NodePair{"node-0", "node-0"} }},
+		{name: "unlimited", pairs: func(i int) NodePair { return NodePair{"node-0", ""} }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pairs := make([]NodePair, 20)
+			for i := range pairs {
+				pairs[i] = test.pairs(i)
+			}
+
+			f := runScheduled(t, context.Background(), 10, pairs, 1, 1)
+			if len(f.ran) != len(pairs) {
+				t.Errorf("%v of %v probes ran", len(f.ran), len(pairs))
+			}
+		})
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	pairs := allPairs(1)
+	for i := 0; i < 10; i++ {
+		pairs = append(pairs, pairs[0])
+	}
+	s := newScheduler(len(pairs), func(i int) NodePair { return pairs[i] }, 1, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.wakeup(ctx)
+
+	// hold the only slot, so every other worker waits
+	if _, ok := s.next(ctx); !ok {
+		t.Fatal("first probe wasn't scheduled")
+	}
+
+	waiters := len(pairs) - 1
+	returned := make(chan bool, waiters)
+	for i := 0; i < waiters; i++ {
+		go func() {
+			_, ok := s.next(ctx)
+			returned <- ok
+		}()
+	}
+
+	select {
+	case <-returned:
+		t.Fatal("probe was scheduled while the slot was held")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	for i := 0; i < waiters; i++ {
+		select {
+		case ok := <-returned:
+			if ok {
+				t.Error("probe was scheduled after cancellation")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v of %v waiters didn't return after cancellation", waiters-i, waiters)
+		}
+	}
+}
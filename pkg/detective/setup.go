@@ -4,10 +4,8 @@ import (
 	"context"
 	"fmt"
 	"strconv"
-	"time"
 
 	core "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -48,10 +46,14 @@ func (d *Detective) deleteNamespace() error {
 func (d *Detective) waitForServiceAccountInNamespace() error {
 	klog.V(2).Info("Waiting for Service Account")
 
-	return wait.PollImmediateUntil(1*time.Second, func() (done bool, err error) {
+	err := d.pollWithTimeout(d.serviceAccountTimeout, func() (done bool, err error) {
 		_, err = d.client.CoreV1().ServiceAccounts(d.namespace.Name).Get(d.tomb.Context(nil), "default", meta.GetOptions{})
 		return err == nil, nil
-	}, d.tomb.Dying())
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("Service account default was not created in %v within %v. Is the service account controller running?", d.namespace.Name, d.serviceAccountTimeout)
+	}
+	return err
 }
 
 func (d *Detective) createPods() error {
@@ -61,19 +63,19 @@ func (d *Detective) createPods() error {
 	}
 
 	klog.V(2).Info("Creating pods")
+	specs := []*core.Pod{}
 	for _, node := range nodes {
-		if !d.tomb.Alive() {
-			return fmt.Errorf("Interrupted")
-		}
-		if _, err := d.createPod(d.createPodSpec(node, false)); err != nil {
-			return err
-		}
-		if _, err := d.createPod(d.createPodSpec(node, true)); err != nil {
-			return err
-		}
+		specs = append(specs, d.createPodSpec(node, false), d.createPodSpec(node, true))
 	}
 
-	return nil
+	return d.createInParallel(len(specs), func(i int) error {
+		pod, err := d.createPod(specs[i])
+		if err != nil {
+			return fmt.Errorf("Failed to create pod on %v: %v", specs[i].Spec.NodeName, err)
+		}
+		d.podsReady.expect(pod.Name, 1)
+		return nil
+	})
 }
 
 func (d *Detective) createPod(pod *core.Pod) (*core.Pod, error) {
@@ -86,7 +88,15 @@ func (d *Detective) createPod(pod *core.Pod) (*core.Pod, error) {
 
 func (d *Detective) createPodSpec(node *core.Node, hostNetwork bool) *core.Pod {
 	var gracePeriod int64 = 2
-	return &core.Pod{
+
+	// hostNetwork pods fall back to the node's resolv.conf unless asked
+	// explicitly to use the cluster DNS
+	dnsPolicy := core.DNSClusterFirst
+	if hostNetwork {
+		dnsPolicy = core.DNSClusterFirstWithHostNet
+	}
+
+	pod := &core.Pod{
 		ObjectMeta: meta.ObjectMeta{
 			GenerateName: "server-",
 			Labels: map[string]string{
@@ -104,38 +114,44 @@ func (d *Detective) createPodSpec(node *core.Node, hostNetwork bool) *core.Pod {
 			},
 			NodeName:                      node.Name,
 			HostNetwork:                   hostNetwork,
+			DNSPolicy:                     dnsPolicy,
 			TerminationGracePeriodSeconds: &gracePeriod,
 		},
 	}
-}
 
-func (d *Detective) waitForPodsRunning() error {
-	klog.V(2).Info("Waiting for running Pods")
+	// the first successful probe marks the pod network ready. Setup only
+	// waits for running pods, so a node failing the probe is still tested.
+	if d.podLatency {
+		pod.Spec.Containers[0].ReadinessProbe = &core.Probe{
+			Handler: core.Handler{
+				HTTPGet: &core.HTTPGetAction{Port: intstr.FromInt(PodHttpPort)},
+			},
+			PeriodSeconds: 1,
+		}
+	}
 
-	nodes, err := d.ListNodesWithPredicate(d.NodeIsSchedulabeleAndRunning)
-	if err != nil {
-		return err
+	// the node diagnostics need to read iptables and conntrack of the node,
+	// packet captures need raw sockets
+	if hostNetwork && (d.nodeDiagnostics || d.captures > 0) {
+		privileged := true
+		pod.Spec.Containers[0].SecurityContext = &core.SecurityContext{Privileged: &privileged}
 	}
 
-	return wait.PollImmediateUntil(1*time.Second, func() (done bool, err error) {
-		pods, err := d.informers.Core().V1().Pods().Lister().Pods(d.namespace.Name).List(labels.Everything())
-		if err != nil {
-			return false, err
-		}
+	return pod
+}
 
-		running := 0
-		for _, pod := range pods {
-			switch pod.Status.Phase {
-			case core.PodRunning:
-				running++
-			case core.PodFailed:
-				return false, fmt.Errorf("Failed to create a Pod: %v", pod.Status.Reason)
-			}
-		}
+func (d *Detective) waitForPodsRunning() error {
+	klog.V(2).Info("Waiting for running Pods")
 
-		klog.V(3).Infof("  %v/%v pods running", running, len(nodes)*2)
-		return running == len(nodes)*2, nil
-	}, d.tomb.Dying())
+	err := d.podsReady.wait(d.tomb.Dying(), d.podStartTimeout)
+	if err == wait.ErrWaitTimeout {
+		stuck, derr := d.diagnoseStuckPods()
+		if derr != nil {
+			return derr
+		}
+		return fmt.Errorf("%v pods did not start within %v", stuck, d.podStartTimeout)
+	}
+	return err
 }
 
 func (d *Detective) createSevices(withExternalIP bool) error {
@@ -146,24 +162,25 @@ func (d *Detective) createSevices(withExternalIP bool) error {
 		return err
 	}
 
+	// specs are built upfront, as each one takes an external IP
+	specs := []*core.Service{}
 	for _, pod := range pods {
-		if !d.tomb.Alive() {
-			return fmt.Errorf("Interrupted")
-		}
-
 		spec, err := d.createServiceSpec(pod, withExternalIP)
 		if err != nil {
 			return err
 		}
+		specs = append(specs, spec)
+	}
 
-		service, err := d.client.CoreV1().Services(d.namespace.Name).Create(d.tomb.Context(nil), spec, meta.CreateOptions{})
+	return d.createInParallel(len(specs), func(i int) error {
+		service, err := d.client.CoreV1().Services(d.namespace.Name).Create(d.tomb.Context(nil), specs[i], meta.CreateOptions{})
 		if err != nil {
-			return err
+			return fmt.Errorf("Failed to create service for %v: %v", specs[i].Labels["podName"], err)
 		}
-		klog.V(3).Infof("  created %v at %v for %v", service.Name, service.Spec.ExternalIPs, pod.Name)
-	}
-
-	return nil
+		d.endpointsReady.expect(service.Name, 1)
+		klog.V(3).Infof("  created %v at %v for %v", service.Name, service.Spec.ExternalIPs, specs[i].Labels["podName"])
+		return nil
+	})
 }
 
 func (d *Detective) createServiceSpec(pod *core.Pod, withExternalIP bool) (*core.Service, error) {
@@ -207,37 +224,12 @@ func (d *Detective) createServiceSpec(pod *core.Pod, withExternalIP bool) (*core
 func (d *Detective) waitForServiceEndpoints() error {
 	klog.V(2).Info("Waiting for service endpoints")
 
-	nodes, err := d.ListNodesWithPredicate(d.NodeIsSchedulabeleAndRunning)
-	if err != nil {
-		return err
-	}
-
-	return wait.PollImmediateUntil(1*time.Second, func() (done bool, err error) {
-		services, err := d.informers.Core().V1().Services().Lister().List(labels.Everything())
-		if err != nil {
-			return false, err
-		}
-
-		klog.V(3).Infof("  found %v nodes", len(nodes))
-		klog.V(3).Infof("  found %v srvices", len(services))
-
-		ready := 0
-		for _, service := range services {
-			endpoints, err := d.informers.Core().V1().Endpoints().Lister().Endpoints(d.namespace.Name).Get(service.Name)
-			if err != nil {
-				if errors.IsNotFound(err) {
-					klog.V(3).Infof("  endpoint %v not found: %v", service.Name, err)
-					continue
-				}
-				klog.V(3).Infof("  endpoint %v error: %v", service.Name, err)
-				return false, err
-			}
-			for _, subset := range endpoints.Subsets {
-				ready = ready + len(subset.Addresses)
-			}
+	err := d.endpointsReady.wait(d.tomb.Dying(), d.endpointsTimeout)
+	if err == wait.ErrWaitTimeout {
+		if derr := d.diagnoseMissingEndpoints(); derr != nil {
+			return derr
 		}
-
-		klog.V(3).Infof("  %v/%v services ready", ready, len(nodes)*2)
-		return ready == len(nodes)*2, nil
-	}, d.tomb.Dying())
+		return fmt.Errorf("Service endpoints not ready within %v", d.endpointsTimeout)
+	}
+	return err
 }
@@ -0,0 +1,118 @@
+package detective
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SuspectNodes is the number of suspect nodes listed
+const SuspectNodes = 10
+
+// NodeScore counts the failed and total probes of a node as source and as
+// target
+type NodeScore struct {
+	Node           string `json:"node"`
+	SourceFailures int    `json:"sourceFailures"`
+	SourceTotal    int    `json:"sourceTotal"`
+	TargetFailures int    `json:"targetFailures"`
+	TargetTotal    int    `json:"targetTotal"`
+}
+
+func ratio(failures, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(failures) / float64(total)
+}
+
+// Score is the higher of the failure ratios as source and as target
+func (s NodeScore) Score() float64 {
+	source, target := ratio(s.SourceFailures, s.SourceTotal), ratio(s.TargetFailures, s.TargetTotal)
+	if source > target {
+		return source
+	}
+	return target
+}
+
+// Direction is the likely direction of the fault. A node failing mostly as
+// source can't send (egress), one failing mostly as target can't receive
+// (ingress).
+func (s NodeScore) Direction() string {
+	source, target := ratio(s.SourceFailures, s.SourceTotal), ratio(s.TargetFailures, s.TargetTotal)
+	switch {
+	case source > 2*target:
+		return "egress"
+	case target > 2*source:
+		return "ingress"
+	}
+	return "both"
+}
+
+// rankSuspectNodes scores every node involved in a failed probe, the most
+// suspect first
+func rankSuspectNodes(results []ProbeResult) []NodeScore {
+	scores := map[string]*NodeScore{}
+	score := func(node string) *NodeScore {
+		if _, ok := scores[node]; !ok {
+			scores[node] = &NodeScore{Node: node}
+		}
+		return scores[node]
+	}
+
+	for _, r := range results {
+		failed := r.Outcome == OutcomeFail
+		if r.Source != "" {
+			s := score(r.Source)
+			s.SourceTotal++
+			if failed {
+				s.SourceFailures++
+			}
+		}
+		if r.Target != "" {
+			s := score(r.Target)
+			s.TargetTotal++
+			if failed {
+				s.TargetFailures++
+			}
+		}
+	}
+
+	var ranked []NodeScore
+	for _, s := range scores {
+		if s.SourceFailures+s.TargetFailures > 0 {
+			ranked = append(ranked, *s)
+		}
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score() != ranked[j].Score() {
+			return ranked[i].Score() > ranked[j].Score()
+		}
+		return ranked[i].Node < ranked[j].Node
+	})
+	return ranked
+}
+
+func (d *Detective) printSuspectNodes() {
+	ranked := rankSuspectNodes(d.results)
+	if len(ranked) == 0 {
+		return
+	}
+
+	fmt.Printf("Suspect Nodes (%v)\n", len(ranked))
+	for i, s := range ranked {
+		if i == SuspectNodes {
+			fmt.Printf("  and %v more\n", len(ranked)-i)
+			break
+		}
+		fmt.Printf("  %30v %5.1f%% %-7v (source %v/%v failed, target %v/%v failed)\n",
+			s.Node,
+			100*s.Score(),
+			s.Direction(),
+			s.SourceFailures,
+			s.SourceTotal,
+			s.TargetFailures,
+			s.TargetTotal,
+		)
+	}
+}
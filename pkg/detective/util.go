@@ -1,11 +1,20 @@
 package detective
 
 import (
+	"context"
+	"fmt"
 	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
 
 	core "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 )
 
@@ -47,6 +56,61 @@ func (d *Detective) ListNodesWithPredicate(predicate func(node *v1.Node) bool) (
 	return filtered, nil
 }
 
+// ListPodServices lists the services created for a single test pod, leaving
+// out services backed by several pods
+func (d *Detective) ListPodServices() ([]*v1.Service, error) {
+	requirement, err := labels.NewRequirement("podName", selection.Exists, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.informers.Core().V1().Services().Lister().Services(d.namespace.Name).List(labels.NewSelector().Add(*requirement))
+}
+
+func (d *Detective) nodeInternalIP(name string) string {
+	node, err := d.informers.Core().V1().Nodes().Lister().Get(name)
+	if err != nil {
+		return ""
+	}
+
+	for _, address := range node.Status.Addresses {
+		if address.Type == core.NodeInternalIP {
+			return address.Address
+		}
+	}
+	return ""
+}
+
+// pollWithTimeout polls condition every second until it is done. It returns
+// wait.ErrWaitTimeout if timeout passes first.
+func (d *Detective) pollWithTimeout(timeout time.Duration, condition wait.ConditionFunc) error {
+	ctx, cancel := context.WithTimeout(d.tomb.Context(nil), timeout)
+	defer cancel()
+
+	err := wait.PollImmediateUntil(1*time.Second, condition, ctx.Done())
+	if err == wait.ErrWaitTimeout && ctx.Err() != context.DeadlineExceeded {
+		return fmt.Errorf("Interrupted")
+	}
+	return err
+}
+
+// createInParallel runs create for each of n objects with createWorkerCount
+// workers. Errors of all objects are aggregated.
+func (d *Detective) createInParallel(n int, create func(i int) error) error {
+	ctx := d.tomb.Context(nil)
+	var result *multierror.Error
+	var mutex sync.Mutex
+
+	workqueue.ParallelizeUntil(ctx, d.createWorkerCount, n, func(i int) {
+		err := create(i)
+		mutex.Lock()
+		result = multierror.Append(result, err)
+		mutex.Unlock()
+	})
+
+	return multierror.Append(result, ctx.Err()).ErrorOrNil()
+}
+
 func inc(ip net.IP) {
 	for j := len(ip) - 1; j >= 0; j-- {
 		ip[j]++